@@ -0,0 +1,166 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+//cachedProvider keeps the most recently defined terms in memory. Entries older
+//than ttl are still served while a background lookup refreshes them, for up
+//to maxStale, so a slow or unreachable upstream doesn't stall the bot.
+type cachedProvider struct {
+	provider
+
+	size     int
+	ttl      time.Duration
+	maxStale time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key        string
+	words      objects.WordDataSlice
+	fetched    time.Time
+	refreshing bool
+}
+
+func newCachedProvider(p provider, size int, ttl, maxStale time.Duration) *cachedProvider {
+	return &cachedProvider{
+		provider: p,
+		size:     size,
+		ttl:      ttl,
+		maxStale: maxStale,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+//cacheKey is the form of term used to look it up in the cache.
+func cacheKey(term string) string {
+	return foldCase(normalizeTerm(term))
+}
+
+//Define returns the cached definitions of term, going upstream when needed.
+func (c *cachedProvider) Define(ctx context.Context, term string) (objects.WordDataSlice, error) {
+	key := cacheKey(term)
+	now := time.Now()
+
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		countEvent("cache_misses")
+		return c.fetch(ctx, key, term)
+	}
+
+	c.order.MoveToFront(el)
+	e := el.Value.(*cacheEntry)
+	words, age := e.words, now.Sub(e.fetched)
+
+	if age < c.ttl {
+		c.mu.Unlock()
+		countEvent("cache_hits")
+		return words, nil
+	}
+
+	if age < c.ttl+c.maxStale {
+		if !e.refreshing {
+			e.refreshing = true
+			go c.refresh(context.WithoutCancel(ctx), key, term)
+		}
+		c.mu.Unlock()
+		countEvent("cache_stale_hits")
+		return words, nil
+	}
+	c.mu.Unlock()
+
+	countEvent("cache_misses")
+	fresh, err := c.fetch(ctx, key, term)
+	if err != nil {
+		logFor(ctx).Warn("Serving expired definitions", "error", err)
+		return words, nil
+	}
+	return fresh, nil
+}
+
+//Suggest asks upstream for suggestions, falling back to the cached terms
+//that look most like term.
+func (c *cachedProvider) Suggest(ctx context.Context, term string) ([]string, error) {
+	terms, err := c.provider.Suggest(ctx, term)
+	if err == nil && len(terms) > 0 {
+		return terms, nil
+	}
+	return similarTerms(term, c.terms()), err
+}
+
+//terms returns the cached words that have definitions.
+func (c *cachedProvider) terms() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var terms []string
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		if words := el.Value.(*cacheEntry).words; len(words.List) > 0 {
+			terms = append(terms, words.List[0].Word)
+		}
+	}
+	return terms
+}
+
+//fetch defines term upstream and stores the result under key.
+func (c *cachedProvider) fetch(ctx context.Context, key, term string) (objects.WordDataSlice, error) {
+	words, err := c.provider.Define(ctx, term)
+	if err != nil {
+		return words, err
+	}
+	c.store(key, words)
+	return words, nil
+}
+
+//refresh re-fetches a stale entry. On failure the stale copy is kept.
+func (c *cachedProvider) refresh(ctx context.Context, key, term string) {
+	if _, err := c.fetch(ctx, key, term); err != nil {
+		logFor(ctx).Warn("Could not refresh cached definitions", "error", err)
+		countEvent("cache_refresh_errors")
+
+		c.mu.Lock()
+		if el, ok := c.entries[key]; ok {
+			el.Value.(*cacheEntry).refreshing = false
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *cachedProvider) store(key string, words objects.WordDataSlice) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*cacheEntry)
+		e.words, e.fetched, e.refreshing = words, time.Now(), false
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, words: words, fetched: time.Now()})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		countEvent("cache_evictions")
+	}
+}
+
+//Len returns the number of cached terms.
+func (c *cachedProvider) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+//maxTop is the most definitions "top" will list. UD rarely returns more than ten.
+const maxTop = 10
+
+const usage = "Usage:\n" +
+	"`/urbano <word>` defines a word\n" +
+	"`/urbano random` returns a random word\n" +
+	"`/urbano top <n> <word>` lists the top n definitions\n" +
+	"`/urbano example <word>` shows an example of the word\n" +
+	"`/urbano id:<defid>` looks up a specific definition\n" +
+	"`/urbano help` prints this message"
+
+//command is a parsed slash command.
+type command struct {
+	name  string
+	term  string
+	count int
+	defid int
+}
+
+const (
+	cmdDefine  = "define"
+	cmdHelp    = "help"
+	cmdRandom  = "random"
+	cmdTop     = "top"
+	cmdExample = "example"
+	cmdID      = "id"
+)
+
+//parseCommand reads the text typed after /urbano. Anything that isn't a
+//subcommand is a word to define. The returned error is meant for the user.
+func parseCommand(text string) (command, error) {
+	fields := strings.Fields(normalizeTerm(text))
+	if len(fields) == 0 {
+		return command{name: cmdHelp}, nil
+	}
+
+	first := strings.ToLower(fields[0])
+	rest := fields[1:]
+
+	switch {
+	case first == cmdHelp || first == "--help" || first == "-h":
+		return command{name: cmdHelp}, nil
+
+	case first == cmdRandom && len(rest) == 0:
+		return command{name: cmdRandom}, nil
+
+	case first == cmdTop && len(rest) > 0:
+		n, err := strconv.Atoi(rest[0])
+		if err != nil || n < 1 || n > maxTop {
+			return command{}, fmt.Errorf("`top` takes a number between 1 and %d, got %q", maxTop, rest[0])
+		}
+		if len(rest) < 2 {
+			return command{}, errors.New("`top` needs a word to define")
+		}
+		return command{name: cmdTop, count: n, term: strings.Join(rest[1:], " ")}, nil
+
+	case first == cmdExample && len(rest) > 0:
+		return command{name: cmdExample, term: strings.Join(rest, " ")}, nil
+
+	case strings.HasPrefix(first, cmdID+":") && len(rest) == 0:
+		defid, err := strconv.Atoi(strings.TrimPrefix(first, cmdID+":"))
+		if err != nil || defid < 1 {
+			return command{}, fmt.Errorf("%q is not a definition id", fields[0])
+		}
+		return command{name: cmdID, defid: defid}, nil
+
+	case strings.HasPrefix(first, "-"):
+		return command{}, fmt.Errorf("unknown option %q", fields[0])
+	}
+
+	return command{name: cmdDefine, term: strings.Join(fields, " ")}, nil
+}
+
+//runCommand builds the reply to cmd.
+func runCommand(ctx context.Context, u objects.SlackIncoming, cmd command) (objects.SlackResponse, error) {
+	switch cmd.name {
+	case cmdHelp:
+		setOutcome(ctx, "help")
+		response := objects.SlackResponse{}
+		response.Text = sanitize(u.Platform, usage)
+		response.ResponseType = "ephemeral"
+		return response, nil
+	case cmdRandom:
+		return randomWord(ctx, u)
+	case cmdTop:
+		return topWords(ctx, u, cmd.term, cmd.count)
+	case cmdExample:
+		return exampleWord(ctx, u, cmd.term)
+	case cmdID:
+		return lookupWord(ctx, u, cmd.defid)
+	}
+	return defineWord(ctx, u, cmd.term)
+}
+
+//defineWord builds the reply to a word lookup.
+func defineWord(ctx context.Context, u objects.SlackIncoming, word string) (objects.SlackResponse, error) {
+	describeTerm(ctx, word)
+
+	return show(ctx, u, pageState{Kind: cmdDefine, Term: word})
+}
+
+//topWords builds the reply listing the best n definitions of word.
+func topWords(ctx context.Context, u objects.SlackIncoming, word string, n int) (objects.SlackResponse, error) {
+	describeTerm(ctx, word)
+
+	return show(ctx, u, pageState{Kind: cmdTop, Term: word, Count: n})
+}
+
+//exampleWord builds the reply with an example of word.
+func exampleWord(ctx context.Context, u objects.SlackIncoming, word string) (objects.SlackResponse, error) {
+	describeTerm(ctx, word)
+
+	return show(ctx, u, pageState{Kind: cmdExample, Term: word})
+}
+
+//lookupWord builds the reply with the definition numbered defid.
+func lookupWord(ctx context.Context, u objects.SlackIncoming, defid int) (objects.SlackResponse, error) {
+	describeTerm(ctx, fmt.Sprintf("%v:%d", cmdID, defid))
+
+	return show(ctx, u, pageState{Kind: cmdID, Defid: defid})
+}
+
+//notFound builds the reply for a word without definitions, offering
+//similar words when there are any.
+func notFound(ctx context.Context, u objects.SlackIncoming, word string) objects.SlackResponse {
+	setOutcome(ctx, "not_found")
+
+	var suggestions []string
+	if !strings.HasPrefix(word, cmdID+":") {
+		suggestions = suggestTerms(ctx, word)
+	}
+	return renderNotFound(u, sanitize(u.Platform, word), suggestions)
+}
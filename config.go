@@ -0,0 +1,489 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+	"gopkg.in/yaml.v2"
+)
+
+//config is everything urbanobot is told at startup. Each setting is a flag,
+//a key of the same name in the -config YAML file and an URBANO_* environment
+//variable (-cache-ttl is cache-ttl and URBANO_CACHE_TTL). Flags win over the
+//environment, which wins over the file, which wins over the defaults.
+type config struct {
+	File string
+
+	HTTPS     bool
+	Port      int
+	Domain    string
+	CertCache string
+	PublicURL string
+
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+	ShutdownDelay   time.Duration
+
+	SigningSecret string
+	ReplayWindow  time.Duration
+	TeamsFile     string
+	Teams         []objects.Team
+
+	UDURL      string
+	CacheSize  int
+	CacheTTL   time.Duration
+	CacheStale time.Duration
+
+	Defer       bool
+	AckText     string
+	Rich        bool
+	Buttons     bool
+	Preview     bool
+	FilterWords string
+	Filter      string
+	Ranking     string
+
+	RandomVotes    int
+	RandomAttempts int
+	RandomPool     int
+
+	SchedulesFile string
+	Schedules     []objects.Schedule
+	History       string
+
+	MetricsAddr  string
+	MetricsToken string
+	AdminToken   string
+	LogFormat    string
+	LogLevel     string
+
+	//sources says where each setting that isn't a default came from.
+	sources map[string]string
+
+	//Filled in by validate.
+	registry map[string]objects.Team
+	jobs     []scheduledJob
+	filter   *classifier
+}
+
+//secretSettings are never printed.
+var secretSettings = map[string]bool{"signing-secret": true, "metrics-token": true, "admin-token": true}
+
+func defaultConfig() *config {
+	return &config{
+		Port:            61000,
+		CertCache:       "/usr/local/etc/urbanobot/urbanocerts",
+		ReadTimeout:     10 * time.Second,
+		WriteTimeout:    30 * time.Second,
+		IdleTimeout:     2 * time.Minute,
+		ShutdownTimeout: 30 * time.Second,
+		ReplayWindow:    5 * time.Minute,
+		UDURL:           defaultUDURL,
+		CacheSize:       1000,
+		CacheTTL:        time.Hour,
+		CacheStale:      24 * time.Hour,
+		Defer:           true,
+		Buttons:         true,
+		Preview:         true,
+		Filter:          filterAllow,
+		Ranking:         "thumbs",
+		RandomVotes:     13000,
+		RandomAttempts:  10,
+		RandomPool:      20,
+		History:         "/usr/local/etc/urbanobot/history.json",
+		LogFormat:       "json",
+		LogLevel:        "info",
+		sources:         make(map[string]string),
+	}
+}
+
+//flagSet binds every setting of c to a flag.
+func (c *config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("urbanobot", flag.ContinueOnError)
+	fs.StringVar(&c.File, "config", c.File, "YAML file with settings. Flags and URBANO_* variables override it.")
+
+	fs.BoolVar(&c.HTTPS, "https", c.HTTPS, "Serve https on :443 with a Let's Encrypt certificate for -domain.")
+	fs.IntVar(&c.Port, "port", c.Port, "Port to use. Ignored if TLS is enabled. $PORT is honored too.")
+	fs.StringVar(&c.Domain, "domain", c.Domain, "Domain to get a certificate for with -https.")
+	fs.StringVar(&c.CertCache, "cert-cache", c.CertCache, "Directory certificates are kept in.")
+	fs.StringVar(&c.PublicURL, "public-url", c.PublicURL, "URL Mattermost buttons post back to. Defaults to https://<domain> with -https.")
+
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "Longest time to read a request.")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "Longest time to answer a request.")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "How long idle keep-alive connections are kept open.")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "How long to wait for requests and deferred replies on SIGTERM or SIGINT.")
+	fs.DurationVar(&c.ShutdownDelay, "shutdown-delay", c.ShutdownDelay, "How long to keep serving, not ready, before shutting down, so load balancers notice.")
+
+	fs.StringVar(&c.SigningSecret, "signing-secret", c.SigningSecret, "Slack signing secret. Requests aren't verified without one.")
+	fs.DurationVar(&c.ReplayWindow, "replay-window", c.ReplayWindow, "Maximum age of a signed Slack request.")
+	fs.StringVar(&c.TeamsFile, "teams", c.TeamsFile, "JSON file listing the teams allowed to use the bot.")
+
+	fs.StringVar(&c.UDURL, "ud-url", c.UDURL, "Base URL of the Urban Dictionary API.")
+	fs.IntVar(&c.CacheSize, "cache-size", c.CacheSize, "Number of terms to keep in memory. 0 disables the cache.")
+	fs.DurationVar(&c.CacheTTL, "cache-ttl", c.CacheTTL, "How long cached definitions are fresh.")
+	fs.DurationVar(&c.CacheStale, "cache-stale", c.CacheStale, "How long expired definitions may be served while refreshing.")
+
+	fs.BoolVar(&c.Defer, "defer", c.Defer, "Acknowledge right away and post answers to response_url.")
+	fs.StringVar(&c.AckText, "ack-text", c.AckText, "Ephemeral message shown while a deferred answer is looked up.")
+	fs.BoolVar(&c.Rich, "rich", c.Rich, "Send Block Kit layouts with example, votes and permalink to Slack.")
+	fs.BoolVar(&c.Buttons, "buttons", c.Buttons, "Add buttons to page through definitions.")
+	fs.BoolVar(&c.Preview, "preview", c.Preview, "Show definitions to the requester first, with a button to post them.")
+	fs.StringVar(&c.FilterWords, "filter-words", c.FilterWords, "File of words and /regexps/ that flag a definition as offensive.")
+	fs.StringVar(&c.Filter, "filter", c.Filter, "What to do with flagged definitions: allow, mask, spoiler or skip.")
+	fs.StringVar(&c.Ranking, "ranking", c.Ranking, "How definitions are ranked: "+rankingNames()+".")
+
+	fs.IntVar(&c.RandomVotes, "random-votes", c.RandomVotes, "Thumbs up a random word needs.")
+	fs.IntVar(&c.RandomAttempts, "random-attempts", c.RandomAttempts, "Random feed pages to try before settling for the best word seen.")
+	fs.IntVar(&c.RandomPool, "random-pool", c.RandomPool, "Random words to prefetch. 0 disables the pool.")
+
+	fs.StringVar(&c.SchedulesFile, "schedules", c.SchedulesFile, "JSON file of word of the day schedules.")
+	fs.StringVar(&c.History, "history", c.History, "Where words of the day already posted are kept.")
+
+	fs.StringVar(&c.MetricsAddr, "metrics-addr", c.MetricsAddr, "Address to serve /metrics on, e.g. 127.0.0.1:9100. Defaults to the main listener.")
+	fs.StringVar(&c.MetricsToken, "metrics-token", c.MetricsToken, "Bearer token required to read metrics.")
+	fs.StringVar(&c.AdminToken, "admin-token", c.AdminToken, "Bearer token required by POST /admin/reload. The endpoint is off without one.")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "Log format: json or text.")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "Least important log level written: debug, info, warn or error.")
+	return fs
+}
+
+//envName is the environment variable for the setting called name.
+func envName(name string) string {
+	return "URBANO_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+//loadConfig builds the configuration from the defaults, the config file,
+//the environment and args, and validates it.
+func loadConfig(args []string) (*config, error) {
+	c := defaultConfig()
+	fs := c.flagSet()
+	fs.SetOutput(ioutil.Discard)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			fs.SetOutput(os.Stderr)
+			printUsage(fs)
+		}
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	fromFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		fromFlags[f.Name] = true
+		c.sources[f.Name] = "flag"
+	})
+
+	if c.File == "" {
+		c.File = os.Getenv(envName("config"))
+		if c.File != "" {
+			c.sources["config"] = "$" + envName("config")
+		}
+	}
+	if c.File != "" {
+		if err := c.readFile(fs, fromFlags); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || fromFlags[f.Name] || f.Name == "config" {
+			return
+		}
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok && f.Name == "port" {
+			//systemd units and Heroku set a bare PORT.
+			name = "PORT"
+			value, ok = os.LookupEnv(name)
+		}
+		if !ok {
+			return
+		}
+		if e := f.Value.Set(value); e != nil {
+			err = fmt.Errorf("$%v: %v", name, e)
+			return
+		}
+		c.sources[f.Name] = "$" + name
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, c.validate()
+}
+
+//readFile applies the settings in the config file that no flag overrides.
+//teams and schedules may be given inline, as YAML lists, instead of files.
+func (c *config) readFile(fs *flag.FlagSet, fromFlags map[string]bool) error {
+	data, err := ioutil.ReadFile(c.File)
+	if err != nil {
+		return err
+	}
+
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("%v: %v", c.File, err)
+	}
+
+	var keys []string
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := settings[key]
+		f := fs.Lookup(key)
+		if f == nil || key == "config" {
+			return fmt.Errorf("%v: unknown setting %q", c.File, key)
+		}
+		if fromFlags[key] {
+			continue
+		}
+
+		switch value.(type) {
+		case []interface{}:
+			var list interface{}
+			switch key {
+			case "teams":
+				list = &c.Teams
+			case "schedules":
+				list = &c.Schedules
+			default:
+				return fmt.Errorf("%v: %v takes a single value", c.File, key)
+			}
+			out, _ := yaml.Marshal(value)
+			if err := yaml.UnmarshalStrict(out, list); err != nil {
+				return fmt.Errorf("%v: %v: %v", c.File, key, err)
+			}
+		case map[interface{}]interface{}:
+			return fmt.Errorf("%v: %v takes a single value", c.File, key)
+		case nil:
+			continue
+		default:
+			if err := f.Value.Set(fmt.Sprint(value)); err != nil {
+				return fmt.Errorf("%v: %v: %v", c.File, key, err)
+			}
+		}
+		c.sources[key] = c.File
+	}
+	return nil
+}
+
+//validate checks every setting and loads the files they point to.
+func (c *config) validate() error {
+	if c.HTTPS && c.Domain == "" {
+		return errors.New("https needs a domain, set -domain or $URBANO_DOMAIN")
+	}
+	if !c.HTTPS && (c.Port < 1 || c.Port > 65535) {
+		return fmt.Errorf("port %d is out of range", c.Port)
+	}
+	if c.PublicURL != "" {
+		if u, err := url.Parse(c.PublicURL); err != nil || u.Host == "" {
+			return fmt.Errorf("public-url %q is not an absolute URL", c.PublicURL)
+		}
+	}
+	if u, err := url.Parse(c.UDURL); err != nil || u.Host == "" {
+		return fmt.Errorf("ud-url %q is not an absolute URL", c.UDURL)
+	}
+
+	for name, d := range map[string]time.Duration{
+		"read-timeout":     c.ReadTimeout,
+		"write-timeout":    c.WriteTimeout,
+		"idle-timeout":     c.IdleTimeout,
+		"shutdown-timeout": c.ShutdownTimeout,
+		"replay-window":    c.ReplayWindow,
+		"cache-ttl":        c.CacheTTL,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("%v must be positive, got %v", name, d)
+		}
+	}
+	if c.ShutdownDelay < 0 {
+		return fmt.Errorf("shutdown-delay can't be negative, got %v", c.ShutdownDelay)
+	}
+	if c.CacheStale < 0 {
+		return fmt.Errorf("cache-stale can't be negative, got %v", c.CacheStale)
+	}
+	for name, n := range map[string]int{"cache-size": c.CacheSize, "random-pool": c.RandomPool, "random-votes": c.RandomVotes} {
+		if n < 0 {
+			return fmt.Errorf("%v can't be negative, got %d", name, n)
+		}
+	}
+	if c.RandomAttempts < 1 {
+		return fmt.Errorf("random-attempts must be at least 1, got %d", c.RandomAttempts)
+	}
+
+	if err := checkRanking(c.Ranking); err != nil {
+		return err
+	}
+	if err := checkFilter(c.Filter); err != nil {
+		return err
+	}
+	if _, err := newLogHandler(c.LogFormat, c.LogLevel); err != nil {
+		return err
+	}
+
+	if c.FilterWords != "" {
+		filter, err := loadFilter(c.FilterWords)
+		if err != nil {
+			return fmt.Errorf("filter-words: %v", err)
+		}
+		c.filter = filter
+	}
+
+	var err error
+	switch {
+	case c.TeamsFile != "" && len(c.Teams) > 0:
+		return errors.New("teams are given both inline and as a file")
+	case c.TeamsFile != "":
+		c.registry, err = loadTeams(c.TeamsFile)
+	case len(c.Teams) > 0:
+		c.registry, err = newRegistry(c.Teams)
+	}
+	if err != nil {
+		return fmt.Errorf("teams: %v", err)
+	}
+
+	switch {
+	case c.SchedulesFile != "" && len(c.Schedules) > 0:
+		return errors.New("schedules are given both inline and as a file")
+	case c.SchedulesFile != "":
+		c.jobs, err = loadSchedules(c.SchedulesFile)
+	case len(c.Schedules) > 0:
+		c.jobs, err = prepareSchedules(c.Schedules)
+	}
+	if err != nil {
+		return fmt.Errorf("schedules: %v", err)
+	}
+	return nil
+}
+
+//live holds the *config in use. It is replaced whole, never modified, so
+//a reload can't leave a request with half of the old settings.
+var live atomic.Value
+
+func init() {
+	live.Store(defaultConfig())
+}
+
+//current returns the configuration in use.
+func current() *config {
+	return live.Load().(*config)
+}
+
+//apply makes c the configuration in use.
+func (c *config) apply() {
+	live.Store(c)
+}
+
+//publicURL is where Mattermost can reach urbanobot: public-url, or the
+//https domain. Mattermost only gets buttons when it is set.
+func (c *config) publicURL() string {
+	if c.PublicURL == "" && c.HTTPS {
+		return "https://" + c.Domain
+	}
+	return c.PublicURL
+}
+
+//print writes the effective configuration to w as YAML, each setting noting
+//where it came from, with secrets and webhook paths redacted.
+func (c *config) print(w io.Writer) {
+	fs := c.flagSet()
+	fs.VisitAll(func(f *flag.Flag) {
+		//Inline lists are printed below.
+		if (f.Name == "teams" && len(c.Teams) > 0) || (f.Name == "schedules" && len(c.Schedules) > 0) {
+			return
+		}
+
+		var value interface{} = f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			value = getter.Get()
+		}
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		if secretSettings[f.Name] && f.Value.String() != "" {
+			value = "REDACTED"
+		}
+
+		line, _ := yaml.Marshal(map[string]interface{}{f.Name: value})
+		source := c.sources[f.Name]
+		if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(w, "%s # %v\n", strings.TrimSuffix(string(line), "\n"), source)
+	})
+
+	if len(c.registry) > 0 {
+		var list []objects.Team
+		for _, t := range c.registry {
+			if t.Token != "" {
+				t.Token = "REDACTED"
+			}
+			list = append(list, t)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		out, _ := yaml.Marshal(map[string]interface{}{"teams": list})
+		fmt.Fprintf(w, "# effective teams\n%s", out)
+	}
+
+	if len(c.jobs) > 0 {
+		var list []objects.Schedule
+		for _, job := range c.jobs {
+			s := job.Schedule
+			s.Targets = nil
+			for _, t := range job.Targets {
+				t.URL = redactURL(t.URL)
+				s.Targets = append(s.Targets, t)
+			}
+			list = append(list, s)
+		}
+		out, _ := yaml.Marshal(map[string]interface{}{"schedules": list})
+		fmt.Fprintf(w, "# effective schedules\n%s", out)
+	}
+}
+
+//redactURL keeps the scheme and host of a webhook URL; the path is its secret.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "REDACTED"
+	}
+	return u.Scheme + "://" + u.Host + "/REDACTED"
+}
+
+func printUsage(fs *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, "Usage:\n  urbanobot [flags]\n  urbanobot config check [flags]\n\nFlags:")
+	fs.PrintDefaults()
+}
+
+//configCheck implements "urbanobot config check": it validates the
+//configuration args would give and prints it. It returns the exit status.
+func configCheck(args []string) int {
+	c, err := loadConfig(args)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return 1
+	}
+	c.print(os.Stdout)
+	return 0
+}
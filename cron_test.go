@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr string
+		ok   bool
+	}{
+		{"30 9 * * *", true},
+		{"0 8 * * 1-5", true},
+		{"*/15 * * * *", true},
+		{"0 0 1,15 * *", true},
+		{"5/10 * * * *", true},
+		{"0 12 * * 7", true},
+		{"@daily", true},
+		{" @hourly ", true},
+		{"", false},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"5-1 * * * *", false},
+		{"*/0 * * * *", false},
+		{"a * * * *", false},
+		{"1-x * * * *", false},
+		{"@sometimes", false},
+	}
+	for _, test := range tests {
+		_, err := parseCron(test.expr)
+		if (err == nil) != test.ok {
+			t.Errorf("parseCron(%q) error = %v, want ok %v", test.expr, err, test.ok)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	at := func(loc *time.Location, year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, loc)
+	}
+	//01:30 happens twice in New York on 2026-11-01, first in EDT then in EST.
+	fallBackFirst := at(time.UTC, 2026, time.November, 1, 5, 30).In(newYork)
+	fallBackSecond := at(time.UTC, 2026, time.November, 1, 6, 30).In(newYork)
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"30 9 * * *", at(time.UTC, 2026, time.March, 7, 12, 0), at(time.UTC, 2026, time.March, 8, 9, 30)},
+		{"30 9 * * *", at(time.UTC, 2026, time.March, 7, 9, 0), at(time.UTC, 2026, time.March, 7, 9, 30)},
+		{"30 9 * * *", at(time.UTC, 2026, time.March, 7, 9, 30), at(time.UTC, 2026, time.March, 8, 9, 30)},
+		{"*/15 * * * *", at(time.UTC, 2026, time.March, 7, 9, 50), at(time.UTC, 2026, time.March, 7, 10, 0)},
+		{"0 8 * * 1-5", at(time.UTC, 2026, time.March, 6, 9, 0), at(time.UTC, 2026, time.March, 9, 8, 0)},
+		{"0 0 1 * *", at(time.UTC, 2026, time.December, 15, 0, 0), at(time.UTC, 2027, time.January, 1, 0, 0)},
+		{"0 0 29 2 *", at(time.UTC, 2026, time.March, 1, 0, 0), at(time.UTC, 2028, time.February, 29, 0, 0)},
+		//Both day fields set: either one matches.
+		{"0 0 13 * 5", at(time.UTC, 2026, time.March, 1, 0, 0), at(time.UTC, 2026, time.March, 6, 0, 0)},
+		{"0 12 * * 7", at(time.UTC, 2026, time.March, 2, 0, 0), at(time.UTC, 2026, time.March, 8, 12, 0)},
+
+		//Spring forward: 02:00-02:59 doesn't exist on 2026-03-08.
+		{"0 2 * * *", at(newYork, 2026, time.March, 7, 12, 0), at(newYork, 2026, time.March, 9, 2, 0)},
+		{"30 9 * * *", at(newYork, 2026, time.March, 7, 12, 0), at(newYork, 2026, time.March, 8, 9, 30)},
+		{"0 * * * *", at(newYork, 2026, time.March, 8, 1, 30), at(newYork, 2026, time.March, 8, 3, 0)},
+		{"30 1 * * *", at(newYork, 2026, time.March, 7, 12, 0), at(newYork, 2026, time.March, 8, 1, 30)},
+
+		//Fall back: 01:00-01:59 happens twice on 2026-11-01.
+		{"30 1 * * *", at(newYork, 2026, time.October, 31, 12, 0), fallBackFirst},
+		{"30 1 * * *", fallBackFirst, at(newYork, 2026, time.November, 2, 1, 30)},
+		{"30 1 * * *", fallBackSecond, at(newYork, 2026, time.November, 2, 1, 30)},
+		{"30 9 * * *", at(newYork, 2026, time.October, 31, 12, 0), at(newYork, 2026, time.November, 1, 9, 30)},
+		{"0 2 * * *", fallBackFirst, at(newYork, 2026, time.November, 1, 2, 0)},
+	}
+	for _, test := range tests {
+		spec, err := parseCron(test.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", test.expr, err)
+		}
+		if got := spec.next(test.from); !got.Equal(test.want) {
+			t.Errorf("%q next after %v = %v, want %v", test.expr, test.from, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+//udWebURL is where cross-references link to.
+const udWebURL = "https://www.urbandictionary.com/define.php?term="
+
+//maxSeeAlso is how many cross-references the "See also" footer lists.
+const maxSeeAlso = 5
+
+//crossReference matches the [bracketed] terms UD links to other definitions.
+var crossReference = regexp.MustCompile(`\[([^\[\]\n]+)\]`)
+
+var slackUnescapes = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">")
+
+//linkDefinitions turns the cross-references in list into links for platform
+//and records them in SeeAlso. Run it on sanitized text.
+func linkDefinitions(platform string, list []objects.WordData) []objects.WordData {
+	for i, wd := range list {
+		list[i].SeeAlso = references(wd.Definition + "\n" + wd.Example)
+		list[i].Definition = linkReferences(platform, wd.Definition)
+		list[i].Example = linkReferences(platform, wd.Example)
+	}
+	return list
+}
+
+//references lists the distinct cross-referenced terms in text, in order.
+func references(text string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, m := range crossReference.FindAllStringSubmatch(text, -1) {
+		if k := cacheKey(m[1]); k != "" && !seen[k] {
+			seen[k] = true
+			terms = append(terms, m[1])
+		}
+	}
+	return terms
+}
+
+//linkReferences rewrites every [term] in text as a link to its definition.
+func linkReferences(platform, text string) string {
+	return crossReference.ReplaceAllStringFunc(text, func(m string) string {
+		label := m[1 : len(m)-1]
+		switch platform {
+		case platformSlack:
+			return "<" + termURL(slackUnescapes.Replace(label)) + "|" + strings.Replace(label, "|", "¦", -1) + ">"
+		case platformMattermost:
+			return "[" + label + "](" + termURL(label) + ")"
+		}
+		return label
+	})
+}
+
+//termURL is the Urban Dictionary page of term.
+func termURL(term string) string {
+	return udWebURL + url.QueryEscape(term)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+//Filter policies, chosen per channel.
+const (
+	//filterAllow shows flagged definitions as they are.
+	filterAllow = "allow"
+	//filterMask replaces flagged words with asterisks.
+	filterMask = "mask"
+	//filterSpoiler hides flagged definitions behind a "Show anyway" button.
+	filterSpoiler = "spoiler"
+	//filterSkip leaves flagged definitions out, so the next clean one is shown.
+	filterSkip = "skip"
+)
+
+//spoilerText stands in for a hidden definition, and hiddenText for one
+//hidden where there is no Show anyway button.
+const (
+	spoilerText = ":warning: This definition may be NSFW. Use *Show anyway* to read it."
+	hiddenText  = ":warning: This definition may be NSFW, so it is hidden in this channel."
+)
+
+var errFiltered = errors.New("every definition was filtered")
+
+//classifier flags text matching any of its patterns.
+type classifier struct {
+	patterns []*regexp.Regexp
+}
+
+//checkFilter makes sure name is a known policy.
+func checkFilter(name string) error {
+	switch name {
+	case filterAllow, filterMask, filterSpoiler, filterSkip:
+		return nil
+	}
+	return fmt.Errorf("unknown filter %q, use one of allow, mask, skip, spoiler", name)
+}
+
+//loadFilter reads a word list, one entry per line. Entries between slashes
+//are regular expressions, anything else is a whole word matched in any case.
+//Blank lines and lines starting with # are ignored.
+func loadFilter(path string) (*classifier, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &classifier{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		expr := `(?i)\b` + regexp.QuoteMeta(entry) + `\b`
+		if len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+			expr = "(?i)" + entry[1:len(entry)-1]
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", path, line, err)
+		}
+		c.patterns = append(c.patterns, re)
+	}
+	return c, scanner.Err()
+}
+
+//flagged reports whether the definition or example of wd matches.
+func (c *classifier) flagged(wd objects.WordData) bool {
+	for _, re := range c.patterns {
+		if re.MatchString(wd.Definition) || re.MatchString(wd.Example) {
+			return true
+		}
+	}
+	return false
+}
+
+//mask replaces every match in text with asterisks.
+func (c *classifier) mask(text string) string {
+	for _, re := range c.patterns {
+		text = re.ReplaceAllStringFunc(text, func(m string) string {
+			return strings.Repeat("*", len([]rune(m)))
+		})
+	}
+	return text
+}
+
+//filterFor returns the policy of the channel u was sent from.
+func filterFor(u objects.SlackIncoming) string {
+	if t, ok := current().registry[u.SlackTeam]; ok {
+		if c, ok := channelSettings(t, u); ok && c.Filter != "" {
+			return c.Filter
+		}
+		if t.Filter != "" {
+			return t.Filter
+		}
+	}
+	return current().Filter
+}
+
+//filterDefinitions applies the channel's policy to list. Definitions hidden
+//as spoilers come back with Flagged set, unless reveal asks to show them.
+func filterDefinitions(ctx context.Context, u objects.SlackIncoming, list []objects.WordData, reveal bool) []objects.WordData {
+	contentFilter := current().filter
+	if contentFilter == nil {
+		return list
+	}
+
+	policy := filterFor(u)
+	spoiler := spoilerText
+	if !canButton(u) {
+		spoiler = hiddenText
+	}
+	var kept []objects.WordData
+	for _, wd := range list {
+		if !contentFilter.flagged(wd) {
+			kept = append(kept, wd)
+			continue
+		}
+
+		countEvent("filter_" + policy)
+		logFor(ctx).Info("Definition flagged", "defid", wd.Defid, "word", wd.Word, "policy", policy)
+
+		switch {
+		case policy == filterSkip:
+			continue
+		case policy == filterMask:
+			wd.Definition = contentFilter.mask(wd.Definition)
+			wd.Example = contentFilter.mask(wd.Example)
+		case policy == filterSpoiler && !reveal:
+			wd.Definition, wd.Example, wd.Flagged = spoiler, "", true
+		}
+		kept = append(kept, wd)
+	}
+	return kept
+}
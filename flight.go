@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+//flight is a lookup in progress that other callers can wait on.
+type flight struct {
+	done  chan struct{}
+	words objects.WordDataSlice
+	err   error
+}
+
+//dedupedProvider makes concurrent Define calls for the same term share a
+//single upstream request.
+type dedupedProvider struct {
+	provider
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newDedupedProvider(p provider) *dedupedProvider {
+	return &dedupedProvider{provider: p, flights: make(map[string]*flight)}
+}
+
+//Define joins the in-flight lookup of term, or starts one.
+func (d *dedupedProvider) Define(ctx context.Context, term string) (objects.WordDataSlice, error) {
+	key := cacheKey(term)
+
+	d.mu.Lock()
+	if f, ok := d.flights[key]; ok {
+		d.mu.Unlock()
+		countEvent("shared_lookups")
+		select {
+		case <-f.done:
+			return f.words, f.err
+		case <-ctx.Done():
+			return objects.WordDataSlice{}, ctx.Err()
+		}
+	}
+	f := &flight{done: make(chan struct{})}
+	d.flights[key] = f
+	d.mu.Unlock()
+
+	//The lookup is shared, so it mustn't end when the caller that
+	//happened to start it gives up.
+	f.words, f.err = d.provider.Define(context.WithoutCancel(ctx), term)
+
+	d.mu.Lock()
+	delete(d.flights, key)
+	d.mu.Unlock()
+	close(f.done)
+
+	return f.words, f.err
+}
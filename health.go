@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Build metadata, set at build time with
+//-ldflags "-X main.buildCommit=... -X main.buildTime=...".
+var (
+	buildCommit = "unknown"
+	buildTime   = "unknown"
+)
+
+//shuttingDown is set once the server starts shutting down, so load
+//balancers stop sending it requests.
+var shuttingDown int32
+
+//readyTimeout bounds each readiness check.
+const readyTimeout = 5 * time.Second
+
+//readyCheck reports on one dependency: a short description when it is
+//fine, an error when it isn't. Failing checks are reported, but don't make
+//the bot unready: the cache still answers while Urban Dictionary is down,
+//and word of the day troubles don't stop slash commands.
+type readyCheck func(ctx context.Context) (string, error)
+
+var (
+	readyMu     sync.Mutex
+	readyChecks = make(map[string]readyCheck)
+)
+
+//addReadyCheck makes /readyz run check under name.
+func addReadyCheck(name string, check readyCheck) {
+	readyMu.Lock()
+	defer readyMu.Unlock()
+	readyChecks[name] = check
+}
+
+//cachedCheck remembers the result of check for ttl, so frequent probes don't
+//turn into a stream of upstream calls.
+func cachedCheck(check readyCheck, ttl time.Duration) readyCheck {
+	var mu sync.Mutex
+	var checked time.Time
+	var detail string
+	var err error
+	return func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(checked) >= ttl {
+			detail, err = check(ctx)
+			checked = time.Now()
+		}
+		return detail, err
+	}
+}
+
+//pingUpstream checks that ud answers.
+func pingUpstream(ud *urbanDictionary) readyCheck {
+	return func(ctx context.Context) (string, error) {
+		start := time.Now()
+		if err := ud.Ping(ctx); err != nil {
+			return "", err
+		}
+		return "reachable in " + time.Since(start).Round(time.Millisecond).String(), nil
+	}
+}
+
+type checkResult struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+//healthz tells whether the process is up at all.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+//readyz reports how the bot's dependencies are doing. It only answers 503
+//while shutting down; failing checks make the status "degraded".
+func readyz(w http.ResponseWriter, r *http.Request) {
+	status := "ready"
+	results := make(map[string]checkResult)
+
+	if atomic.LoadInt32(&shuttingDown) != 0 {
+		status = "shutting down"
+	} else {
+		readyMu.Lock()
+		var names []string
+		for name := range readyChecks {
+			names = append(names, name)
+		}
+		readyMu.Unlock()
+		sort.Strings(names)
+
+		for _, name := range names {
+			readyMu.Lock()
+			check := readyChecks[name]
+			readyMu.Unlock()
+
+			ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+			detail, err := check(ctx)
+			cancel()
+
+			result := checkResult{OK: err == nil, Detail: detail}
+			if err != nil {
+				result.Error = err.Error()
+				status = "degraded"
+			}
+			results[name] = result
+		}
+	}
+
+	code := http.StatusOK
+	if status == "shutting down" {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{"status": status, "checks": results})
+}
+
+//versionInfo answers /version.
+func versionInfo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"version":    version,
+		"commit":     buildCommit,
+		"build_time": buildTime,
+		"go_version": runtime.Version(),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	data, _ := json.MarshalIndent(v, "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//postedWord is a word a schedule has posted.
+type postedWord struct {
+	Word   string    `json:"word"`
+	Defid  int       `json:"defid"`
+	Posted time.Time `json:"posted"`
+}
+
+//wordHistory remembers what each schedule posted, on disk, so restarts
+//don't bring repeats.
+type wordHistory struct {
+	path string
+
+	mu      sync.Mutex
+	Posted  map[string][]postedWord `json:"posted"`
+	saveErr error
+}
+
+//openHistory loads the history kept at path. A missing file is an empty history.
+func openHistory(path string) (*wordHistory, error) {
+	h := &wordHistory{path: path, Posted: make(map[string][]postedWord)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, err
+	}
+	if h.Posted == nil {
+		h.Posted = make(map[string][]postedWord)
+	}
+	return h, nil
+}
+
+//seen reports whether schedule posted word since since.
+func (h *wordHistory) seen(schedule, word string, since time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := cacheKey(word)
+	for _, p := range h.Posted[schedule] {
+		if p.Posted.After(since) && cacheKey(p.Word) == key {
+			return true
+		}
+	}
+	return false
+}
+
+//record adds a post, forgets the ones older than keep and saves the history.
+func (h *wordHistory) record(schedule string, p postedWord, keep time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cutoff := p.Posted.Add(-keep)
+	var posted []postedWord
+	for _, old := range h.Posted[schedule] {
+		if old.Posted.After(cutoff) {
+			posted = append(posted, old)
+		}
+	}
+	h.Posted[schedule] = append(posted, p)
+
+	h.saveErr = h.save()
+	return h.saveErr
+}
+
+//status reports how many schedules have history and whether it was last
+//saved fine, for /readyz.
+func (h *wordHistory) status(ctx context.Context) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.saveErr != nil {
+		return "", h.saveErr
+	}
+	return fmt.Sprintf("%d schedules at %v", len(h.Posted), h.path), nil
+}
+
+//save writes the history next to its final path and renames it into place.
+func (h *wordHistory) save() error {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+
+	tmp := h.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+// interactivePath is the endpoint button clicks are sent to.
+const interactivePath = "/urbano/v1/interactive"
+
+const (
+	actionNext    = "next"
+	actionPrev    = "prev"
+	actionSend    = "send"
+	actionCancel  = "cancel"
+	actionReveal  = "reveal"
+	actionDefine  = "define"
+	actionSeeAlso = "see"
+)
+
+var errBadState = errors.New("button state does not verify")
+
+// stateKey signs the state carried by buttons so clicks can't be forged.
+var stateKey []byte
+
+// initStateKey picks the key button states are signed with: the signing
+// secret at startup when there is one, otherwise a random key that lasts
+// until restart. Reloads keep it so buttons already posted still work.
+func initStateKey() {
+	if signingSecret := current().SigningSecret; signingSecret != "" {
+		stateKey = []byte(signingSecret)
+		return
+	}
+	stateKey = make([]byte, 32)
+	if _, err := rand.Read(stateKey); err != nil {
+		fatal("Could not generate a button key", "error", err)
+	}
+}
+
+// pageState is what a button remembers about the message it's on, enough
+// to render it again.
+type pageState struct {
+	Kind        string `json:"k,omitempty"`
+	Term        string `json:"t,omitempty"`
+	Index       int    `json:"i,omitempty"`
+	Count       int    `json:"n,omitempty"`
+	Defid       int    `json:"d,omitempty"`
+	Preview     bool   `json:"p,omitempty"`
+	Reveal      bool   `json:"x,omitempty"`
+	Hidden      bool   `json:"-"`
+	Team        string `json:"m,omitempty"`
+	ResponseURL string `json:"r,omitempty"`
+}
+
+// encodeState serializes s with a signature.
+func encodeState(s pageState) string {
+	data, _ := json.Marshal(s)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signState(payload)
+}
+
+// decodeState checks the signature on value and returns its state.
+func decodeState(value string) (pageState, error) {
+	var s pageState
+
+	i := strings.LastIndex(value, ".")
+	if i < 0 || !hmac.Equal([]byte(signState(value[:i])), []byte(value[i+1:])) {
+		return s, errBadState
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value[:i])
+	if err != nil {
+		return s, errBadState
+	}
+	err = json.Unmarshal(data, &s)
+	return s, err
+}
+
+func signState(payload string) string {
+	mac := hmac.New(sha256.New, stateKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// canButton reports whether replies to u can carry buttons.
+func canButton(u objects.SlackIncoming) bool {
+	c := current()
+	switch u.Platform {
+	case platformSlack:
+		return c.Buttons
+	case platformMattermost:
+		return c.Buttons && c.publicURL() != ""
+	}
+	return false
+}
+
+// present finishes a reply showing state: previews go only to the requester
+// with buttons to post or cancel, and pages of a definition get buttons to
+// move through the rest.
+func present(u objects.SlackIncoming, response objects.SlackResponse, state pageState, total int) objects.SlackResponse {
+	if !canButton(u) {
+		return response
+	}
+
+	//Mattermost previews are posted through the command's response_url.
+	state.Preview = previewFor(u) && (u.Platform != platformMattermost || u.Response_url != "")
+	return addControls(u, response, state, total)
+}
+
+// addControls adds the buttons state calls for to response: preview ones,
+// keeping it with the requester, when state.Preview is set, and paging and
+// reveal ones.
+func addControls(u objects.SlackIncoming, response objects.SlackResponse, state pageState, total int) objects.SlackResponse {
+	if !canButton(u) {
+		return response
+	}
+	if state.Preview {
+		response.ResponseType = "ephemeral"
+	}
+	if u.Platform == platformMattermost {
+		state.Team, state.ResponseURL = u.SlackTeam, u.Response_url
+	}
+	//Terms too long to fit in a button get no buttons at all. Previews of
+	//them stay with the requester.
+	if len(encodeState(state)) > maxButtonValue {
+		return response
+	}
+	actions := pageActions(state, total)
+	if len(actions) == 0 {
+		return response
+	}
+
+	var position string
+	if total > 1 {
+		position = fmt.Sprintf("Definition %d of %d", state.Index+1, total)
+	}
+
+	switch u.Platform {
+	case platformSlack:
+		if len(response.Blocks) == 0 {
+			response.Blocks = []objects.Block{{Type: "section", Text: mrkdwn(truncate(response.Text, maxBlockText))}}
+		}
+		if position != "" {
+			response.Blocks = append(response.Blocks, objects.Block{Type: "context", Elements: []interface{}{mrkdwn(position)}})
+		}
+		response.Blocks = append(response.Blocks, objects.Block{Type: "actions", BlockID: "controls", Elements: slackControls(state, actions)})
+	case platformMattermost:
+		response.Attachments = append(response.Attachments, objects.Attachment{
+			Text:    position,
+			Actions: mattermostControls(state, actions),
+		})
+	}
+	return response
+}
+
+// pageActions lists the buttons that make sense on a message showing state
+// out of total definitions.
+func pageActions(state pageState, total int) []string {
+	var actions []string
+	if state.Index > 0 && total > 1 {
+		actions = append(actions, actionPrev)
+	}
+	if state.Index < total-1 {
+		actions = append(actions, actionNext)
+	}
+	if state.Hidden {
+		actions = append(actions, actionReveal)
+	}
+	if state.Preview {
+		actions = append(actions, actionSend, actionCancel)
+	}
+	return actions
+}
+
+var actionLabels = map[string]string{
+	actionPrev:   "Previous",
+	actionNext:   "Next",
+	actionSend:   "Post to channel",
+	actionCancel: "Cancel",
+	actionReveal: "Show anyway",
+}
+
+func slackControls(state pageState, actions []string) []interface{} {
+	var elements []interface{}
+	value := encodeState(state)
+	for _, action := range actions {
+		b := objects.Button{Type: "button", Text: plainText(actionLabels[action]), ActionID: action, Value: value}
+		if action == actionSend {
+			b.Style = "primary"
+		}
+		elements = append(elements, b)
+	}
+	return elements
+}
+
+func mattermostControls(state pageState, actions []string) []objects.AttachmentAction {
+	var controls []objects.AttachmentAction
+	value := encodeState(state)
+	for _, action := range actions {
+		controls = append(controls, mattermostButton(action, actionLabels[action], action, value))
+	}
+	return controls
+}
+
+// mattermostButton makes a button that posts action and the encoded state
+// value back to public-url.
+func mattermostButton(id, name, action, value string) objects.AttachmentAction {
+	return objects.AttachmentAction{
+		ID:   id,
+		Name: name,
+		Integration: objects.Integration{
+			URL:     strings.TrimRight(current().publicURL(), "/") + interactivePath,
+			Context: map[string]string{"action": action, "state": value},
+		},
+	}
+}
+
+// interact handles button clicks from Slack (a form with a JSON payload) and
+// Mattermost (a JSON body).
+func interact(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		interactMattermost(w, r)
+		return
+	}
+	interactSlack(w, r)
+}
+
+func interactSlack(w http.ResponseWriter, r *http.Request) {
+	var payload objects.SlackAction
+	if err := json.Unmarshal([]byte(r.PostFormValue("payload")), &payload); err != nil || len(payload.Actions) == 0 {
+		setOutcome(r.Context(), "bad_request")
+		logFor(r.Context()).Warn("Could not decode interaction payload", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	u := objects.SlackIncoming{
+		SlackUser:    payload.User.Username,
+		SlackChannel: payload.Channel.Name,
+		SlackTeam:    payload.Team.ID,
+		Token:        payload.Token,
+		Channel_id:   payload.Channel.ID,
+		Response_url: payload.ResponseURL,
+		Team_domain:  payload.Team.Domain,
+		User_id:      payload.User.ID,
+		Platform:     platformSlack,
+	}
+	describe(r.Context(), u)
+	if err := authorizeTeam(u, isSigned(r)); err != nil {
+		rejectAction(w, r, err)
+		return
+	}
+
+	//Clicks are only answered through response_url, which has to be Slack's.
+	if err := checkResponseURL(platformSlack, payload.ResponseURL); err != nil {
+		rejectAction(w, r, err)
+		return
+	}
+
+	action := payload.Actions[0]
+	w.WriteHeader(http.StatusOK)
+
+	ctx := context.WithoutCancel(r.Context())
+	goBackground(func() {
+		name := strings.SplitN(action.ActionID, "-", 2)[0]
+		response, state, err := runAction(ctx, u, name, action.Value)
+		if err != nil {
+			logFor(ctx).Error("Could not answer button click", "action", name, "error", err)
+			return
+		}
+
+		responses := []objects.SlackResponse{response}
+		if name == actionSend && state.Preview {
+			responses = append(responses, objects.SlackResponse{DeleteOriginal: true})
+		}
+		for _, response := range responses {
+			if err := postResponse(ctx, payload.ResponseURL, response); err != nil {
+				countEvent("response_url_failures")
+				logFor(ctx).Error("Could not update message", "error", err)
+				return
+			}
+		}
+	})
+}
+
+func interactMattermost(w http.ResponseWriter, r *http.Request) {
+	var payload objects.MattermostAction
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &payload)
+	}
+	if err != nil {
+		setOutcome(r.Context(), "bad_request")
+		logFor(r.Context()).Warn("Could not decode interaction payload", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	u := objects.SlackIncoming{
+		SlackUser:    payload.UserName,
+		SlackChannel: payload.ChannelName,
+		SlackTeam:    payload.TeamID,
+		Channel_id:   payload.ChannelID,
+		Team_domain:  payload.TeamDomain,
+		User_id:      payload.UserID,
+		Platform:     platformMattermost,
+	}
+	describe(r.Context(), u)
+
+	//Mattermost doesn't sign clicks. The signed state proves the button is
+	//ours, and it records the team it was rendered for.
+	action, value := payload.Context["action"], payload.Context["state"]
+	state, err := decodeState(value)
+	if err == nil && state.Team != u.SlackTeam {
+		err = errBadState
+	}
+	if teams := current().registry; err == nil && len(teams) > 0 {
+		if _, ok := teams[u.SlackTeam]; !ok {
+			err = errUnknownTeam
+		}
+	}
+	if err != nil {
+		rejectAction(w, r, err)
+		return
+	}
+
+	var result objects.MattermostActionResponse
+	response, _, err := runAction(r.Context(), u, action, value)
+	switch {
+	case err != nil:
+		setOutcome(r.Context(), "error")
+		logFor(r.Context()).Error("Could not answer button click", "action", action, "error", err)
+		result.EphemeralText = "Something went wrong, try again in a bit."
+	case action == actionSend:
+		if err := postResponse(r.Context(), state.ResponseURL, response); err != nil {
+			logFor(r.Context()).Error("Could not post to channel", "error", err)
+			result.EphemeralText = "Could not post it to the channel."
+			break
+		}
+		result.Update = &objects.MattermostUpdate{Message: "Posted to the channel.", Props: map[string]interface{}{}}
+	case action == actionCancel:
+		result.Update = &objects.MattermostUpdate{Message: "Cancelled.", Props: map[string]interface{}{}}
+	default:
+		result.Update = &objects.MattermostUpdate{
+			Message: response.Text,
+			Props:   map[string]interface{}{"attachments": response.Attachments},
+		}
+	}
+
+	resp, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// rejectAction refuses a button click that can't be trusted.
+func rejectAction(w http.ResponseWriter, r *http.Request, err error) {
+	countEvent("token_failures")
+	setOutcome(r.Context(), "unauthorized")
+	logFor(r.Context()).Warn("Rejecting interaction", "error", err)
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// runAction builds the message that answers a button click, along with the
+// state the button carried.
+func runAction(ctx context.Context, u objects.SlackIncoming, action, value string) (objects.SlackResponse, pageState, error) {
+	//Slack's define buttons carry the bare term; Mattermost needs signed state
+	//for every button, to tell which team it was rendered for.
+	var state pageState
+	if (action == actionDefine || action == actionSeeAlso) && u.Platform == platformSlack {
+		state = pageState{Kind: cmdDefine, Term: value}
+	} else {
+		var err error
+		if state, err = decodeState(value); err != nil {
+			countEvent("signature_failures")
+			return objects.SlackResponse{}, state, err
+		}
+	}
+	if state.ResponseURL != "" {
+		u.Response_url = state.ResponseURL
+	}
+
+	describeTerm(ctx, state.Term)
+	logFor(ctx).Info("Button clicked", "action", action, "kind", state.Kind, "index", state.Index)
+
+	switch action {
+	case actionCancel:
+		return objects.SlackResponse{DeleteOriginal: true}, state, nil
+	case actionSend:
+		//The channel copy goes through the channel's filter again, whatever
+		//the requester revealed, and keeps its reveal and paging buttons.
+		posted := state
+		posted.Preview, posted.Reveal = false, false
+		response, total, err := view(ctx, u, &posted)
+		if err != nil {
+			return response, state, err
+		}
+		return addControls(u, attribute(u, response), posted, total), state, nil
+	case actionDefine:
+		response, err := show(ctx, u, state)
+		response.ReplaceOriginal = true
+		return response, state, err
+	case actionSeeAlso:
+		//See also buttons are on channel messages too, so the definition
+		//goes to whoever clicked as a new preview, leaving the message be.
+		state.Preview = true
+		response, err := reshow(ctx, u, state)
+		return response, state, err
+	case actionNext:
+		state.Index++
+	case actionPrev:
+		state.Index--
+	case actionReveal:
+		state.Reveal = true
+	}
+
+	response, err := reshow(ctx, u, state)
+	response.ReplaceOriginal = true
+	return response, state, err
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+//fakeProvider answers every term with the same definitions.
+type fakeProvider struct {
+	list []objects.WordData
+}
+
+func (p fakeProvider) Define(ctx context.Context, term string) (objects.WordDataSlice, error) {
+	return objects.WordDataSlice{List: p.list}, nil
+}
+
+func (p fakeProvider) Random(ctx context.Context) (objects.WordDataSlice, error) {
+	return objects.WordDataSlice{List: p.list}, nil
+}
+
+func (p fakeProvider) Lookup(ctx context.Context, defid int) (objects.WordData, error) {
+	return p.list[0], nil
+}
+
+func (p fakeProvider) Suggest(ctx context.Context, term string) ([]string, error) {
+	return nil, nil
+}
+
+//useConfig makes c the configuration in use until t is done.
+func useConfig(t *testing.T, c *config) {
+	old, _ := live.Load().(*config)
+	t.Cleanup(func() {
+		if old != nil {
+			old.apply()
+		}
+	})
+	c.apply()
+	initStateKey()
+}
+
+//useSpoilers sets up a configuration hiding definitions that say "rude"
+//behind spoilers, and a dictionary with one such definition.
+func useSpoilers(t *testing.T) {
+	oldDictionary := dictionary
+	t.Cleanup(func() {
+		dictionary = oldDictionary
+	})
+
+	c := defaultConfig()
+	c.Filter = filterSpoiler
+	c.filter = &classifier{patterns: []*regexp.Regexp{regexp.MustCompile("rude")}}
+	useConfig(t, c)
+	dictionary = fakeProvider{list: []objects.WordData{{Word: "word", Definition: "something rude", Defid: 1}}}
+}
+
+//buttonIDs lists the action ids of the buttons in a Slack response.
+func buttonIDs(t *testing.T, response objects.SlackResponse) []string {
+	data, err := json.Marshal(response.Blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []struct {
+		Type     string `json:"type"`
+		Elements []struct {
+			ActionID string `json:"action_id"`
+		} `json:"elements"`
+	}
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, b := range blocks {
+		if b.Type != "actions" {
+			continue
+		}
+		for _, e := range b.Elements {
+			ids = append(ids, e.ActionID)
+		}
+	}
+	return ids
+}
+
+func TestSendHidesRevealedSpoiler(t *testing.T) {
+	useSpoilers(t)
+	u := objects.SlackIncoming{SlackUser: "someone", Platform: platformSlack}
+
+	//Revealed in a preview, then posted to the channel.
+	preview := pageState{Kind: cmdDefine, Term: "word", Preview: true, Reveal: true}
+	response, state, err := runAction(context.Background(), u, actionSend, encodeState(preview))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Preview {
+		t.Error("send should report the preview it replaces")
+	}
+	if response.ResponseType != "in_channel" {
+		t.Errorf("response type = %q, want in_channel", response.ResponseType)
+	}
+	if strings.Contains(response.Text, "rude") || !strings.Contains(response.Text, spoilerText) {
+		t.Errorf("posted text = %q, want the spoiler", response.Text)
+	}
+	if ids := buttonIDs(t, response); len(ids) != 1 || ids[0] != actionReveal {
+		t.Errorf("posted buttons = %v, want only %v", ids, actionReveal)
+	}
+}
+
+func TestSpoilerWithoutButtons(t *testing.T) {
+	useSpoilers(t)
+	current().Buttons = false
+
+	u := objects.SlackIncoming{Platform: platformSlack}
+	list := filterDefinitions(context.Background(), u, dictionary.(fakeProvider).list, false)
+	if len(list) != 1 || list[0].Definition != hiddenText {
+		t.Errorf("filtered = %+v, want %q", list, hiddenText)
+	}
+}
+
+func TestDefineClicks(t *testing.T) {
+	useSpoilers(t)
+	u := objects.SlackIncoming{Platform: platformSlack}
+
+	tests := []struct {
+		action    string
+		replace   bool
+		ephemeral bool
+	}{
+		//Suggestions replace the "not found" message they're on.
+		{actionDefine, true, true},
+		//See also buttons can be on channel messages, which stay as they are.
+		{actionSeeAlso, false, true},
+	}
+	for _, test := range tests {
+		response, _, err := runAction(context.Background(), u, test.action, "word")
+		if err != nil {
+			t.Fatalf("%v: %v", test.action, err)
+		}
+		if response.ReplaceOriginal != test.replace {
+			t.Errorf("%v: replace_original = %v, want %v", test.action, response.ReplaceOriginal, test.replace)
+		}
+		if (response.ResponseType == "ephemeral") != test.ephemeral {
+			t.Errorf("%v: response type = %q", test.action, response.ResponseType)
+		}
+	}
+}
+
+func TestDecodeState(t *testing.T) {
+	useConfig(t, defaultConfig())
+	state := pageState{Kind: cmdDefine, Term: "word", Index: 2, Team: "T1", ResponseURL: "https://hooks.slack.com/x"}
+	value := encodeState(state)
+
+	got, err := decodeState(value)
+	if err != nil || got != state {
+		t.Errorf("decodeState(encodeState(%+v)) = %+v, %v", state, got, err)
+	}
+
+	other := encodeState(pageState{Kind: cmdDefine, Term: "other"})
+	for _, bad := range []string{
+		"",
+		"word",
+		value[:len(value)-1],
+		value + "x",
+		//Someone else's payload with this signature.
+		other[:strings.LastIndex(other, ".")] + value[strings.LastIndex(value, "."):],
+		"!!!." + value[strings.LastIndex(value, ".")+1:],
+	} {
+		if _, err := decodeState(bad); err != errBadState {
+			t.Errorf("decodeState(%q) = %v, want %v", bad, err, errBadState)
+		}
+	}
+}
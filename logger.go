@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+//requestIDHeader carries the request ID to clients and to Urban Dictionary.
+const requestIDHeader = "X-Request-ID"
+
+const requestKey contextKey = signedKey + 1
+
+//setupLogging sends every log line, including the standard log package's,
+//through a handler of the given format (json or text) and minimum level.
+func setupLogging(format, level string) error {
+	handler, err := newLogHandler(format, level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler).With("version", version))
+	return nil
+}
+
+//newLogHandler returns a handler writing to stderr in format, json or text,
+//from level up.
+func newLogHandler(format, level string) (slog.Handler, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.NewJSONHandler(os.Stderr, opts), nil
+	case "text":
+		return slog.NewTextHandler(os.Stderr, opts), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, use json or text", format)
+}
+
+//fatal logs msg as an error and exits.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+//requestInfo is what gets logged about a request. Handlers fill it in as
+//they learn who is asking for what.
+type requestInfo struct {
+	id    string
+	start time.Time
+
+	mu                        sync.Mutex
+	team, channel, user, term string
+	outcome                   string
+}
+
+//newRequestID returns a random ID for a request that didn't bring one.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//withRequestInfo starts the request info of a new request, keeping the ID
+//of one that came with it.
+func withRequestInfo(ctx context.Context, id string) context.Context {
+	if id == "" || len(id) > 64 {
+		id = newRequestID()
+	}
+	return context.WithValue(ctx, requestKey, &requestInfo{id: id, start: time.Now()})
+}
+
+func infoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestKey).(*requestInfo)
+	return info
+}
+
+//requestID returns the ID of the request ctx belongs to.
+func requestID(ctx context.Context) string {
+	if info := infoFrom(ctx); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+//describe records who sent the request ctx belongs to.
+func describe(ctx context.Context, u objects.SlackIncoming) {
+	if info := infoFrom(ctx); info != nil {
+		info.mu.Lock()
+		info.team, info.channel, info.user = u.SlackTeam, u.SlackChannel, u.SlackUser
+		info.mu.Unlock()
+	}
+}
+
+//describeTerm records what the request ctx belongs to looked up.
+func describeTerm(ctx context.Context, term string) {
+	if info := infoFrom(ctx); info != nil {
+		info.mu.Lock()
+		info.term = term
+		info.mu.Unlock()
+	}
+}
+
+//setOutcome records how the request ctx belongs to ended.
+func setOutcome(ctx context.Context, outcome string) {
+	if info := infoFrom(ctx); info != nil {
+		info.mu.Lock()
+		info.outcome = outcome
+		info.mu.Unlock()
+	}
+}
+
+//logFor returns a logger carrying the ID and caller of the request ctx belongs to.
+func logFor(ctx context.Context) *slog.Logger {
+	info := infoFrom(ctx)
+	if info == nil {
+		return slog.Default()
+	}
+
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	args := []interface{}{"request_id", info.id}
+	for _, f := range []struct{ key, value string }{
+		{"team", info.team}, {"channel", info.channel}, {"user", info.user}, {"term", info.term},
+	} {
+		if f.value != "" {
+			args = append(args, f.key, f.value)
+		}
+	}
+	return slog.Default().With(args...)
+}
+
+//logRequest writes the summary line of the request ctx belongs to.
+func logRequest(ctx context.Context, msg string, args ...interface{}) {
+	info := infoFrom(ctx)
+	if info == nil {
+		return
+	}
+
+	info.mu.Lock()
+	outcome := info.outcome
+	info.mu.Unlock()
+
+	args = append(args, "latency", time.Since(info.start).String(), "outcome", outcome)
+	logFor(ctx).Info(msg, args...)
+}
@@ -1,16 +1,17 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"encoding/json"
 	"errors"
+	"expvar"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"strings"
+	"time"
 
 	"github.com/ajg/form"
 	"github.com/gorilla/mux"
@@ -21,247 +22,327 @@ import (
 const version = "1.2.2"
 
 func main() {
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "check" {
+		os.Exit(configCheck(os.Args[3:]))
+	}
 
-	useTLS := flag.Bool("https", false, "use https by default.")
-	usePort := flag.Int("port", 61000, "Port to use. Ignored if TLS is enabled.")
-	flag.Parse()
+	cfg, err := loadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		os.Exit(2)
+	}
+	if err := setupLogging(cfg.LogFormat, cfg.LogLevel); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	cfg.apply()
+	if cfg.File != "" {
+		slog.Info("Loaded configuration", "file", cfg.File)
+	}
 
-	router := mux.NewRouter().StrictSlash(true)
+	if cfg.filter != nil {
+		slog.Info("Filtering definitions", "patterns", len(cfg.filter.patterns))
+	}
 
-	router.HandleFunc("/urbano/v1/word", getWord)
-	router.HandleFunc("/urbano/v1/random", getRandomWord)
+	ud := newUrbanDictionary(cfg.UDURL)
+	addReadyCheck("upstream", cachedCheck(pingUpstream(ud), 15*time.Second))
+	dictionary = newDedupedProvider(ud)
+	if cfg.CacheSize > 0 {
+		cache := newCachedProvider(dictionary, cfg.CacheSize, cfg.CacheTTL, cfg.CacheStale)
+		stats.Set("cache_entries", expvar.Func(func() interface{} { return cache.Len() }))
+		addReadyCheck("cache", func(ctx context.Context) (string, error) {
+			return fmt.Sprintf("%d of %d entries", cache.Len(), cfg.CacheSize), nil
+		})
+		dictionary = cache
+	}
 
-	if *useTLS {
-		//Check for the domain
-		domain := os.Getenv("URBANO_DOMAIN")
-		if domain == "" {
-			log.Fatal("$URBANO_DOMAIN must be set")
-		}
-		log.Printf("Starting up urbanobot %v on %v using https...\n", version, domain)
-		//Get certificate and store it under /usr/local/etc. Auto-renewed.
-		certManager := &autocert.Manager{
-			Prompt:     autocert.AcceptTOS,
-			HostPolicy: autocert.HostWhitelist(domain),
-			Cache:      autocert.DirCache("/usr/local/etc/urbanobot/urbanocerts"),
-		}
+	if len(cfg.registry) > 0 {
+		slog.Info("Serving authorized teams", "teams", len(cfg.registry))
+	}
 
-		//Start server on the https port
-		server := &http.Server{
-			Addr:    ":https",
-			Handler: router,
-			TLSConfig: &tls.Config{
-				GetCertificate: certManager.GetCertificate,
-			},
-		}
-		log.Fatal(server.ListenAndServeTLS("", ""))
+	if cfg.SigningSecret == "" {
+		slog.Warn("No signing secret set, Slack request signatures will not be verified")
+	}
+	initStateKey()
 
-	} else {
-		log.Printf("Starting up urbanobot %v on port %v...\n", version, *usePort)
+	//Things to stop on shutdown, besides the servers.
+	var stop []func()
 
-		log.Fatal(http.ListenAndServe(":"+fmt.Sprintf("%v", *usePort), router))
+	if cfg.RandomPool > 0 {
+		randomPool = newWordPool(cfg.RandomPool)
+		stats.Set("random_pool", expvar.Func(func() interface{} { return len(randomPool.words) }))
+		go randomPool.fill()
+		stop = append(stop, randomPool.stop)
 	}
-}
 
-//GetWord
-func getWord(w http.ResponseWriter, r *http.Request) {
-
-	var word, slackUser, slackChannel, slackTeam string
+	schedules := &scheduleRunner{historyPath: cfg.History}
+	if err := schedules.run(cfg.jobs); err != nil {
+		fatal("Could not open word history", "file", cfg.History, "error", err)
+	}
+	stop = append(stop, schedules.shutdown)
+	if len(cfg.jobs) > 0 {
+		slog.Info("Running word of the day schedules", "schedules", len(cfg.jobs))
+	}
 
-	if strings.Contains(r.Header.Get("User-Agent"), "Slackbot") {
-		w.Header().Set("Content-Type", "application/json")
-		word = r.URL.Query().Get("text")
+	router := mux.NewRouter().StrictSlash(true)
 
-		slackUser = r.URL.Query().Get("user_name")
-		slackChannel = r.URL.Query().Get("channel_name")
-		slackTeam = r.URL.Query().Get("team_id")
+	router.HandleFunc("/healthz", healthz)
+	router.HandleFunc("/readyz", readyz)
+	router.HandleFunc("/version", versionInfo)
+	router.HandleFunc("/urbano/v1/word", instrument("word", verifySlack(getWord)))
+	router.HandleFunc("/urbano/v1/random", instrument("random", verifySlack(getRandomWord)))
+	router.HandleFunc(interactivePath, instrument("interactive", verifySlack(interact))).Methods("POST")
 
-		log.Print("Slack request received for " + word + " from " + slackUser + ", from team " + slackTeam + ", on channel " + slackChannel)
-	} else {
-		w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
-		var u objects.SlackIncoming
+	//Sockets passed by systemd take the place of port, https and
+	//metrics-addr. One named "metrics" serves the metrics.
+	activated, err := systemdListeners()
+	if err != nil {
+		fatal("Could not use the sockets systemd passed", "error", err)
+	}
+	metricsListeners := activated[metricsSocket]
+	delete(activated, metricsSocket)
+	var listeners []net.Listener
+	for _, ls := range activated {
+		listeners = append(listeners, ls...)
+	}
+	//systemd holds the ports then, so binding one here would fail anyway.
+	if activated != nil && len(listeners) == 0 {
+		fatal("systemd only passed metrics sockets; give the main socket another FileDescriptorName")
+	}
 
-		d := form.NewDecoder(r.Body)
-		if err2 := d.Decode(&u); err2 != nil {
-			fmt.Printf("Form could not be decoded - %v", err2)
-			return
+	//Metrics go on their own listener when metrics-addr is set or systemd
+	//passed one, next to the slash commands otherwise.
+	//Raw counters are only served on a listener of their own.
+	metricsRouter := router
+	if cfg.MetricsAddr != "" || len(metricsListeners) > 0 {
+		metricsRouter = mux.NewRouter()
+		metricsRouter.Handle("/debug/vars", protectMetrics(http.HandlerFunc(serveVars)))
+	}
+	metricsRouter.Handle("/metrics", protectMetrics(http.HandlerFunc(serveMetrics)))
+
+	//Reloads go next to the metrics, off the public listener when possible.
+	rl := &reloader{args: os.Args[1:], schedules: schedules}
+	rl.reloadOnSignal()
+	metricsRouter.HandleFunc("/admin/reload", rl.serveReload).Methods("POST")
+	var servers []*http.Server
+	if cfg.MetricsAddr != "" && len(metricsListeners) == 0 {
+		l, err := net.Listen("tcp", cfg.MetricsAddr)
+		if err != nil {
+			fatal("Could not listen for metrics", "addr", cfg.MetricsAddr, "error", err)
+		}
+		metricsListeners = append(metricsListeners, l)
+	}
+	if len(metricsListeners) > 0 {
+		metricsServer := newServer(cfg.MetricsAddr, metricsRouter)
+		servers = append(servers, metricsServer)
+		for _, l := range metricsListeners {
+			slog.Info("Serving metrics", "addr", l.Addr().String())
+			serve(metricsServer, l, false)
 		}
-
-		word = u.Text
-		slackUser = u.SlackUser
-		slackChannel = u.SlackChannel
-		slackTeam = u.SlackTeam
-
-		log.Print("Other request received for " + word + " from " + slackUser + ", from team " + slackTeam + ", on channel " + slackChannel)
 	}
 
-	if word == "" {
-		response := objects.SlackResponse{}
-		response.Text = "Screw you, @barnes. Happy now?"
-		response.ResponseType = "in_channel"
-		response.BotVersion = version
-
-		resp, err := json.Marshal(response)
+	addr := ":" + fmt.Sprintf("%v", cfg.Port)
+	if cfg.HTTPS {
+		addr = ":https"
+	}
+	server := newServer(addr, router)
+	if cfg.HTTPS {
+		//Get certificates and keep them in the cert cache. Auto-renewed.
+		certManager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.Domain),
+			Cache:      autocert.DirCache(cfg.CertCache),
+		}
+		server.TLSConfig = &tls.Config{
+			GetCertificate: certManager.GetCertificate,
+		}
+	}
+	if len(listeners) == 0 {
+		l, err := net.Listen("tcp", addr)
 		if err != nil {
-			log.Println("Error Marshalling response!")
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(resp)
-			return
-
+			fatal("Could not listen", "addr", addr, "error", err)
 		}
-
-		w.WriteHeader(http.StatusOK)
-		w.Write(resp)
-		return
-
+		listeners = append(listeners, l)
 	}
-	wordDefinition, err := getWordDefinition(word)
-	if fmt.Sprintf("%s", err) == "NOTFOUND" {
-		log.Println("Word " + word + " not found.")
-		w.WriteHeader(http.StatusOK)
+	servers = append(servers, server)
+	done := shutdownOnSignal(servers, stop...)
 
-		response := objects.SlackResponse{}
-		response.Text = fmt.Sprintf("%s - Word not found", word)
-		response.ResponseType = "ephemeral"
-		response.BotVersion = version
+	for _, l := range listeners {
+		slog.Info("Starting up urbanobot", "addr", l.Addr().String(), "https", cfg.HTTPS, "domain", cfg.Domain, "commit", buildCommit)
+		serve(server, l, cfg.HTTPS)
+	}
+	sdNotify("READY=1")
+	startWatchdog()
+	<-done
+}
 
-		resp, err := json.Marshal(response)
-		if err != nil {
-			log.Println("Error Marshalling response!")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+//serve runs server on l in the background until it is shut down.
+func serve(server *http.Server, l net.Listener, useTLS bool) {
+	go func() {
+		var err error
+		if useTLS {
+			err = server.ServeTLS(l, "", "")
+		} else {
+			err = server.Serve(l)
 		}
+		if err != http.ErrServerClosed {
+			fatal("Server stopped", "addr", l.Addr().String(), "error", err)
+		}
+	}()
+}
 
-		w.Write(resp)
+//GetWord
+func getWord(w http.ResponseWriter, r *http.Request) {
+
+	u, ok := readIncoming(w, r)
+	if !ok {
 		return
 	}
 
+	cmd, err := parseCommand(u.Text)
 	if err != nil {
-		log.Print("Error ", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		setOutcome(r.Context(), "bad_command")
+		logFor(r.Context()).Info("Bad command", "text", u.Text, "error", err)
+		response := objects.SlackResponse{}
+		response.Text = sanitize(u.Platform, err.Error()+"\n"+usage)
+		response.ResponseType = "ephemeral"
+		sendResponse(w, response)
 		return
 	}
 
-	log.Print("Returning definition of " + word + " to " + slackUser + " from team " + slackTeam + " on channel " + slackChannel)
-
-	w.WriteHeader(http.StatusOK)
-	response := objects.SlackResponse{}
-	response.Text = fmt.Sprintf("%s --> %s", word, wordDefinition.Definition)
-	response.ResponseType = "in_channel"
-	response.BotVersion = version
+	reply(w, r, u, func(ctx context.Context) (objects.SlackResponse, error) {
+		return runCommand(ctx, u, cmd)
+	})
+}
 
-	resp, err := json.Marshal(response)
-	if err != nil {
-		resp, err := json.Marshal(response)
-		if err != nil {
-			log.Println("Error Marshalling response!")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+//readIncoming decodes the slash command sent by Slack (query string) or
+//Mattermost (form body) and makes sure the team may use the bot. When it
+//returns false a reply has already been written.
+func readIncoming(w http.ResponseWriter, r *http.Request) (objects.SlackIncoming, bool) {
+	var u objects.SlackIncoming
 
-		w.Write(resp)
-		return
+	platform := platformOf(r)
 
+	if err := r.ParseForm(); err != nil {
+		setOutcome(r.Context(), "bad_request")
+		logFor(r.Context()).Warn("Form could not be parsed", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return u, false
 	}
-	w.Write(resp)
-}
-
-func getWordDefinition(wordToDefine string) (objects.WordData, error) {
-	var UDURL = "http://api.urbandictionary.com/v0/define?term=" + strings.Replace(wordToDefine, " ", "", -1)
-	wd := objects.WordDataSlice{}
-	var word objects.WordData
-
-	resp, err := http.Get(UDURL)
-	if err != nil {
-		return word, err
+	d := form.NewDecoder(nil)
+	d.IgnoreUnknownKeys(true)
+	if err := d.DecodeValues(&u, r.Form); err != nil {
+		setOutcome(r.Context(), "bad_request")
+		logFor(r.Context()).Warn("Form could not be decoded", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return u, false
+	}
+	u.Platform = platform
+
+	describe(r.Context(), u)
+	logFor(r.Context()).Debug("Slash command received", "platform", platform, "text", u.Text)
+
+	switch err := authorizeTeam(u, isSigned(r)); err {
+	case nil:
+		//Whoever can reach the bot can name any response_url, so only
+		//authenticated requests get answers posted to one.
+		if u.Response_url != "" {
+			if err := trustResponseURL(r, u.Platform, u.Response_url); err != nil {
+				logFor(r.Context()).Info("Answering inline instead of through response_url", "error", err)
+				u.Response_url = ""
+			}
+		}
+		return u, true
+	case errUnknownTeam:
+		countEvent("unauthorized_teams")
+		setOutcome(r.Context(), "unauthorized")
+		logFor(r.Context()).Warn("Team is not authorized", "domain", u.Team_domain)
+		response := objects.SlackResponse{}
+		response.Text = "This workspace is not authorized to use urbanobot."
+		response.ResponseType = "ephemeral"
+		sendResponse(w, response)
+	default:
+		countEvent("token_failures")
+		setOutcome(r.Context(), "unauthorized")
+		logFor(r.Context()).Warn("Rejecting request", "error", err)
+		w.WriteHeader(http.StatusUnauthorized)
 	}
+	return u, false
+}
 
-	defer resp.Body.Close()
+var errNotFound = errors.New("NOTFOUND")
 
-	data, _ := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return word, err
+//getDefinitions returns the definitions of a word, best first by ranking.
+//Phrases UD doesn't know are tried again without spaces.
+func getDefinitions(ctx context.Context, wordToDefine, ranking string) ([]objects.WordData, error) {
+	list, err := fetchDefinitions(ctx, wordToDefine, ranking)
+	if err == errNotFound && squash(wordToDefine) != wordToDefine {
+		return fetchDefinitions(ctx, squash(wordToDefine), ranking)
 	}
+	return list, err
+}
 
-	err = json.Unmarshal([]byte(string(data)), &wd)
+func fetchDefinitions(ctx context.Context, wordToDefine, ranking string) ([]objects.WordData, error) {
+	wd, err := dictionary.Define(ctx, wordToDefine)
 	if err != nil {
-		return word, err
+		return nil, err
 	}
 
+	var list []objects.WordData
 	for _, element := range wd.List {
-		if element.ThumbsUp > word.ThumbsUp {
-			word = element
+		if element.Definition != "" {
+			list = append(list, element)
 		}
 	}
-	if word.Definition == "" {
-		return word, errors.New("NOTFOUND")
+	if len(list) == 0 {
+		return nil, errNotFound
 	}
-	return word, nil
+
+	rank(list, ranking)
+	return list, nil
 }
 
-//GetWord
+//GetRandomWord
 func getRandomWord(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	slackUser := r.URL.Query().Get("user_name")
-	slackChannel := r.URL.Query().Get("channel_name")
-	slackTeam := r.URL.Query().Get("team_id")
-	log.Print("Request for random word received from " + slackUser + ", from team " + slackTeam + ", on channel " + slackChannel)
-
-	wordDefinition, err := getNewWord()
-	if err != nil {
-		log.Print("Error ", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	u, ok := readIncoming(w, r)
+	if !ok {
 		return
 	}
 
-	log.Print("Returning random to " + slackUser + " from team " + slackTeam + " on channel " + slackChannel)
-
-	w.WriteHeader(http.StatusOK)
-	response := objects.SlackResponse{}
-	response.Text = fmt.Sprintf("%s --> %s", wordDefinition.Word, wordDefinition.Definition)
-	response.ResponseType = "in_channel"
-	response.BotVersion = version
-
-	resp, err := json.Marshal(response)
-	if err != nil {
-		log.Println("Error Marshalling response!")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	w.Write(resp)
+	reply(w, r, u, func(ctx context.Context) (objects.SlackResponse, error) {
+		return randomWord(ctx, u)
+	})
 }
 
-//getNewWord gets a random UD word
-func getNewWord() (objects.WordData, error) {
-	var UDURL = "http://api.urbandictionary.com/v0/random"
-	wd := objects.WordDataSlice{}
-	var word objects.WordData
-	var good = false
-	tu := 13000
-
-	for good == false {
-		resp, err := http.Get(UDURL)
-		if err != nil {
-			return word, err
-		}
-		defer resp.Body.Close()
-
-		data, _ := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return word, err
+//randomWord builds the reply with a random word.
+func randomWord(ctx context.Context, u objects.SlackIncoming) (objects.SlackResponse, error) {
+	//Words the channel's filter skips don't count, try another one.
+	wordDefinition, err := getNewWord(ctx, func(wd objects.WordData) (objects.WordData, bool) {
+		list := filterDefinitions(ctx, u, []objects.WordData{wd}, false)
+		if len(list) == 0 {
+			return wd, false
 		}
+		return list[0], true
+	})
+	if err == errNoRandomWord {
+		setOutcome(ctx, "filtered")
+		response := objects.SlackResponse{}
+		response.Text = "Could not find a random word clean enough for this channel"
+		response.ResponseType = "ephemeral"
+		return response, nil
+	}
+	if err != nil {
+		return objects.SlackResponse{}, err
+	}
+	wordDefinition = linkDefinitions(u.Platform, sanitizeDefinitions(u.Platform, []objects.WordData{wordDefinition}))[0]
 
-		err = json.Unmarshal([]byte(string(data)), &wd)
-		if err != nil {
-			return word, err
-		}
+	describeTerm(ctx, wordDefinition.Word)
+	setOutcome(ctx, "ok")
 
-		for _, element := range wd.List {
-			if element.ThumbsUp > tu {
-				word = element
-				good = true
-			}
-		}
-	}
-	return word, nil
+	response := renderDefinition(u, wordDefinition.Word, wordDefinition)
+	state := pageState{Kind: cmdID, Defid: wordDefinition.Defid, Hidden: wordDefinition.Flagged}
+	return present(u, response, state, 0), nil
 }
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	requestsTotal = newCounterVec("urbanobot_requests_total", "Requests served, by endpoint, platform and status.", "endpoint", "platform", "status")
+
+	upstreamSeconds = newHistogramVec("urbanobot_upstream_duration_seconds", "Latency of Urban Dictionary calls.",
+		[]float64{.05, .1, .25, .5, 1, 2.5, 5, 10}, "call")
+	upstreamErrors = newCounterVec("urbanobot_upstream_errors_total", "Failed Urban Dictionary calls.", "call")
+)
+
+//counterVec is a Prometheus counter with labels.
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+//inc adds one to the counter with the given label values.
+func (c *counterVec) inc(values ...string) {
+	key := labelPairs(c.labels, values)
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s{%s} %v\n", c.name, key, c.values[key])
+	}
+}
+
+//histogramVec is a Prometheus histogram with labels.
+type histogramVec struct {
+	name, help string
+	buckets    []float64
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, buckets: buckets, labels: labels, series: make(map[string]*histogram)}
+}
+
+//observe records v in the histogram with the given label values.
+func (h *histogramVec) observe(v float64, values ...string) {
+	key := labelPairs(h.labels, values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		for i, le := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%v\"} %d\n", h.name, key, le, s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, key, s.count)
+		fmt.Fprintf(w, "%s_sum{%s} %v\n", h.name, key, s.sum)
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.name, key, s.count)
+	}
+}
+
+//labelPairs renders label names and values as name="value",...
+func labelPairs(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		var v string
+		if i < len(values) {
+			v = values[i]
+		}
+		v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+		pairs[i] = name + `="` + v + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//serveMetrics writes every metric in the Prometheus text format. The
+//counters in stats are exported as urbanobot_<name>_total, and its gauges
+//as urbanobot_<name>.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	requestsTotal.write(out)
+	upstreamSeconds.write(out)
+	upstreamErrors.write(out)
+
+	hits, misses := statValue("cache_hits")+statValue("cache_stale_hits"), statValue("cache_misses")
+	ratio := 0.0
+	if hits+misses > 0 {
+		ratio = hits / (hits + misses)
+	}
+	fmt.Fprintf(out, "# HELP urbanobot_cache_hit_ratio Share of lookups answered from the cache.\n# TYPE urbanobot_cache_hit_ratio gauge\nurbanobot_cache_hit_ratio %v\n", ratio)
+
+	stats.Do(func(kv expvar.KeyValue) {
+		switch v := kv.Value.(type) {
+		case *expvar.Int:
+			name := "urbanobot_" + kv.Key + "_total"
+			fmt.Fprintf(out, "# TYPE %s counter\n%s %v\n", name, name, v.Value())
+		case expvar.Func:
+			if n, ok := v.Value().(int); ok {
+				name := "urbanobot_" + kv.Key
+				fmt.Fprintf(out, "# TYPE %s gauge\n%s %d\n", name, name, n)
+			}
+		}
+	})
+}
+
+//serveVars answers /debug/vars with urbanobot's own counters only. The
+//stock expvar handler also publishes the command line, secrets included.
+func serveVars(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n%q: %s\n}\n", "urbanobot", stats.String())
+}
+
+//statValue returns the named counter in stats, zero when never counted.
+func statValue(name string) float64 {
+	if v, ok := stats.Get(name).(*expvar.Int); ok {
+		return float64(v.Value())
+	}
+	return 0
+}
+
+//protectMetrics only lets requests carrying metricsToken through, when one is set.
+func protectMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if metricsToken := current().MetricsToken; metricsToken != "" && !constantTimeEqual(r.Header.Get("Authorization"), "Bearer "+metricsToken) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//statusRecorder remembers the status a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+//instrument counts and logs the requests handled by next under endpoint.
+//Each request gets an ID, taken from X-Request-ID when the caller sent one,
+//that is echoed back and passed on to Urban Dictionary.
+func instrument(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := withRequestInfo(r.Context(), r.Header.Get(requestIDHeader))
+		w.Header().Set(requestIDHeader, requestID(ctx))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r.WithContext(ctx))
+		requestsTotal.inc(endpoint, platformOf(r), fmt.Sprint(rec.status))
+		logRequest(ctx, "Request handled", "endpoint", endpoint, "platform", platformOf(r), "status", rec.status)
+	}
+}
+
+//platformOf guesses which chat platform sent r.
+func platformOf(r *http.Request) string {
+	if strings.Contains(r.Header.Get("User-Agent"), "Slackbot") {
+		return platformSlack
+	}
+	return platformMattermost
+}
+
+//timeUpstream records how long an Urban Dictionary call took and whether it failed.
+func timeUpstream(call string, start time.Time, err error) {
+	upstreamSeconds.observe(time.Since(start).Seconds(), call)
+	if err != nil {
+		upstreamErrors.inc(call)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+//slackMarkup matches Slack's escaped entities: <@U123>, <#C123|general>,
+//<!here> and <http://example.com|label>.
+var slackMarkup = regexp.MustCompile(`<([^<>|]*)(?:\|([^<>]*))?>`)
+
+var slackEntities = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+
+//normalizeTerm cleans up what the user typed: mentions dropped, links
+//replaced by their label, Unicode in NFC and whitespace collapsed.
+func normalizeTerm(text string) string {
+	text = slackMarkup.ReplaceAllStringFunc(text, func(m string) string {
+		parts := slackMarkup.FindStringSubmatch(m)
+		target, label := parts[1], parts[2]
+		switch {
+		case strings.HasPrefix(target, "@"), strings.HasPrefix(target, "#"), strings.HasPrefix(target, "!"):
+			return " "
+		case label != "":
+			return label
+		}
+		return target
+	})
+	text = slackEntities.Replace(text)
+	text = norm.NFC.String(text)
+	return strings.Join(strings.Fields(text), " ")
+}
+
+//foldCase maps text to a single case so lookups ignore capitalization.
+func foldCase(text string) string {
+	return strings.Map(func(r rune) rune {
+		return unicode.ToLower(unicode.ToUpper(r))
+	}, text)
+}
+
+//squash removes the spaces from a phrase, the way UD often files them.
+func squash(term string) string {
+	return strings.Replace(term, " ", "", -1)
+}
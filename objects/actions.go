@@ -0,0 +1,69 @@
+package objects
+
+//SlackAction is the payload Slack posts when someone clicks a button.
+type SlackAction struct {
+	Type        string `json:"type"`
+	Token       string `json:"token"`
+	ResponseURL string `json:"response_url"`
+	User        struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+		TeamID   string `json:"team_id"`
+	} `json:"user"`
+	Team struct {
+		ID     string `json:"id"`
+		Domain string `json:"domain"`
+	} `json:"team"`
+	Channel struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"channel"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		BlockID  string `json:"block_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+//MattermostAction is the request Mattermost sends for an interactive message button.
+type MattermostAction struct {
+	UserID      string            `json:"user_id"`
+	UserName    string            `json:"user_name"`
+	ChannelID   string            `json:"channel_id"`
+	ChannelName string            `json:"channel_name"`
+	TeamID      string            `json:"team_id"`
+	TeamDomain  string            `json:"team_domain"`
+	PostID      string            `json:"post_id"`
+	Context     map[string]string `json:"context"`
+}
+
+//MattermostActionResponse updates the message whose button was clicked.
+type MattermostActionResponse struct {
+	Update        *MattermostUpdate `json:"update,omitempty"`
+	EphemeralText string            `json:"ephemeral_text,omitempty"`
+}
+
+//MattermostUpdate is the new content of a message.
+type MattermostUpdate struct {
+	Message string                 `json:"message"`
+	Props   map[string]interface{} `json:"props"`
+}
+
+//Attachment is a Mattermost message attachment, used for its buttons.
+type Attachment struct {
+	Text    string             `json:"text,omitempty"`
+	Actions []AttachmentAction `json:"actions,omitempty"`
+}
+
+//AttachmentAction is a Mattermost interactive button.
+type AttachmentAction struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Integration Integration `json:"integration"`
+}
+
+//Integration tells Mattermost where to send a button click, and what with.
+type Integration struct {
+	URL     string            `json:"url"`
+	Context map[string]string `json:"context"`
+}
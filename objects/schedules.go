@@ -0,0 +1,18 @@
+package objects
+
+//Schedule posts a word of the day to incoming webhooks.
+type Schedule struct {
+	Name     string   `json:"name" yaml:"name"`
+	Cron     string   `json:"cron" yaml:"cron"`
+	TimeZone string   `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	Targets  []Target `json:"targets" yaml:"targets"`
+
+	//AvoidDays is how long a posted word won't be picked again.
+	AvoidDays int `json:"avoid_days,omitempty" yaml:"avoid_days,omitempty"`
+}
+
+//Target is an incoming webhook to post to.
+type Target struct {
+	Platform string `json:"platform" yaml:"platform"`
+	URL      string `json:"url" yaml:"url"`
+}
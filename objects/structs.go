@@ -16,6 +16,7 @@ type SlackIncoming struct {
 	Response_url string `form:"response_url"`
 	Team_domain  string `form:"team_domain"`
 	User_id      string `form:"user_id"`
+	Platform     string `form:"-"`
 }
 
 type Response struct {
@@ -34,10 +35,24 @@ type WordData struct {
 	ThumbsUp    int    `json:"thumbs_up"`
 	ThumbsDown  int    `json:"thumbs_down"`
 	Word        string `json:"word"`
+
+	//Ranking and Score record how urbanobot ranked the definition.
+	Ranking string  `json:"-"`
+	Score   float64 `json:"-"`
+
+	//Flagged is set when the definition is hidden by the content filter.
+	Flagged bool `json:"-"`
+
+	//SeeAlso lists the terms the definition cross-references.
+	SeeAlso []string `json:"-"`
 }
 
 type SlackResponse struct {
-	Text         string `json:"text"`
-	ResponseType string `json:"response_type"`
-	BotVersion   string `json:"bot_version"`
+	Text            string       `json:"text"`
+	ResponseType    string       `json:"response_type"`
+	Blocks          []Block      `json:"blocks,omitempty"`
+	Attachments     []Attachment `json:"attachments,omitempty"`
+	ReplaceOriginal bool         `json:"replace_original,omitempty"`
+	DeleteOriginal  bool         `json:"delete_original,omitempty"`
+	BotVersion      string       `json:"bot_version"`
 }
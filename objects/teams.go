@@ -0,0 +1,21 @@
+package objects
+
+//Team is a workspace allowed to use the bot.
+type Team struct {
+	ID       string `json:"team_id" yaml:"team_id"`
+	Domain   string `json:"team_domain" yaml:"team_domain"`
+	Token    string `json:"token" yaml:"token"`
+	Platform string `json:"platform" yaml:"platform"`
+	Ranking  string `json:"ranking,omitempty" yaml:"ranking,omitempty"`
+	Preview  *bool  `json:"preview,omitempty" yaml:"preview,omitempty"`
+	Filter   string `json:"filter,omitempty" yaml:"filter,omitempty"`
+
+	//Channels overrides team settings, keyed by channel id or name.
+	Channels map[string]Channel `json:"channels,omitempty" yaml:"channels,omitempty"`
+}
+
+//Channel holds the settings of a single channel.
+type Channel struct {
+	Preview *bool  `json:"preview,omitempty" yaml:"preview,omitempty"`
+	Filter  string `json:"filter,omitempty" yaml:"filter,omitempty"`
+}
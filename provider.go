@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+//provider is a source of slang definitions.
+type provider interface {
+	//Define returns every definition of term.
+	Define(ctx context.Context, term string) (objects.WordDataSlice, error)
+	//Random returns a batch of random definitions.
+	Random(ctx context.Context) (objects.WordDataSlice, error)
+	//Lookup returns the definition with the given id.
+	Lookup(ctx context.Context, defid int) (objects.WordData, error)
+	//Suggest returns known terms that look like term.
+	Suggest(ctx context.Context, term string) ([]string, error)
+}
+
+//dictionary is the provider used by the handlers.
+var dictionary provider = newUrbanDictionary(defaultUDURL)
+
+const defaultUDURL = "http://api.urbandictionary.com/v0"
+
+//urbanDictionary talks to the Urban Dictionary API, or anything that looks like it.
+type urbanDictionary struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newUrbanDictionary(baseURL string) *urbanDictionary {
+	return &urbanDictionary{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+//Define asks UD for term.
+func (ud *urbanDictionary) Define(ctx context.Context, term string) (objects.WordDataSlice, error) {
+	return ud.get(ctx, "/define?term="+url.QueryEscape(term))
+}
+
+//Random asks UD for its random feed.
+func (ud *urbanDictionary) Random(ctx context.Context) (objects.WordDataSlice, error) {
+	return ud.get(ctx, "/random")
+}
+
+//Lookup asks UD for a single definition.
+func (ud *urbanDictionary) Lookup(ctx context.Context, defid int) (objects.WordData, error) {
+	wd, err := ud.get(ctx, "/define?defid="+strconv.Itoa(defid))
+	if err != nil {
+		return objects.WordData{}, err
+	}
+	for _, element := range wd.List {
+		if element.Defid == defid {
+			return element, nil
+		}
+	}
+	return objects.WordData{}, errNotFound
+}
+
+//Suggest asks UD's autocomplete for term.
+func (ud *urbanDictionary) Suggest(ctx context.Context, term string) ([]string, error) {
+	var terms []string
+	err := ud.getJSON(ctx, "/autocomplete?term="+url.QueryEscape(term), &terms)
+	return terms, err
+}
+
+//Ping checks that UD answers, with a cheap autocomplete call.
+func (ud *urbanDictionary) Ping(ctx context.Context) error {
+	var terms []string
+	return ud.getJSON(ctx, "/autocomplete?term=urban", &terms)
+}
+
+func (ud *urbanDictionary) get(ctx context.Context, path string) (objects.WordDataSlice, error) {
+	wd := objects.WordDataSlice{}
+	err := ud.getJSON(ctx, path, &wd)
+	return wd, err
+}
+
+//getJSON decodes the answer to path into v. The ID of the request ctx
+//belongs to is passed along so both sides' logs can be matched up.
+func (ud *urbanDictionary) getJSON(ctx context.Context, path string, v interface{}) (err error) {
+	call := strings.TrimPrefix(strings.SplitN(path, "?", 2)[0], "/")
+	start := time.Now()
+	defer func() {
+		timeUpstream(call, start, err)
+		logFor(ctx).Debug("Urban Dictionary call", "call", call, "latency", time.Since(start).String(), "error", err)
+	}()
+
+	req, err := http.NewRequest("GET", ud.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	if id := requestID(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+
+	resp, err := ud.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("urban dictionary returned %v for %v", resp.Status, path)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
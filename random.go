@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"time"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+//prefetchInterval spaces out the pool's requests to the random feed.
+const prefetchInterval = time.Second
+
+//randomPool holds prefetched random words. Nil when prefetching is off.
+var randomPool *wordPool
+
+//wordFilter decides whether a random word will do, and returns it the way
+//it should be shown.
+type wordFilter func(objects.WordData) (objects.WordData, bool)
+
+var errNoRandomWord = errors.New("no random word would do")
+
+//getNewWord gets a random UD word that accept takes, from the pool when it
+//has one ready. Pool words accept turns down are handed back for others.
+func getNewWord(ctx context.Context, accept wordFilter) (objects.WordData, error) {
+	if randomPool != nil {
+		//Handed back words come round again, so don't go past the pool size.
+		for tries := 0; tries < cap(randomPool.words); tries++ {
+			word, ok := randomPool.take()
+			if !ok {
+				break
+			}
+			if shown, ok := accept(word); ok {
+				countEvent("random_pool_hits")
+				return shown, nil
+			}
+			randomPool.giveBack(word)
+		}
+		countEvent("random_pool_misses")
+	}
+	return findRandomWord(ctx, accept)
+}
+
+//findRandomWord reads the random feed until it finds a word accept takes
+//with more than random-votes thumbs up. After random-attempts pages it
+//settles for the best word seen that accept takes.
+func findRandomWord(ctx context.Context, accept wordFilter) (objects.WordData, error) {
+	var seen []objects.WordData
+	var lastErr error
+	c := current()
+
+	for attempt := 0; attempt < c.RandomAttempts; attempt++ {
+		if attempt > 0 {
+			countEvent("random_retries")
+		}
+
+		wd, err := dictionary.Random(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		for _, element := range wd.List {
+			if element.Definition == "" {
+				continue
+			}
+			if element.ThumbsUp <= c.RandomVotes {
+				seen = append(seen, element)
+				continue
+			}
+			if shown, ok := accept(element); ok {
+				return shown, nil
+			}
+		}
+	}
+
+	sort.SliceStable(seen, func(i, j int) bool { return seen[i].ThumbsUp > seen[j].ThumbsUp })
+	for _, element := range seen {
+		if shown, ok := accept(element); ok {
+			countEvent("random_fallbacks")
+			logFor(ctx).Info("Settling for the best random word seen", "threshold", c.RandomVotes, "attempts", c.RandomAttempts, "word", element.Word)
+			return shown, nil
+		}
+	}
+	if lastErr != nil {
+		return objects.WordData{}, lastErr
+	}
+	return objects.WordData{}, errNoRandomWord
+}
+
+//wordPool keeps qualifying random words ready to hand out.
+type wordPool struct {
+	words chan objects.WordData
+	done  chan struct{}
+}
+
+func newWordPool(size int) *wordPool {
+	return &wordPool{words: make(chan objects.WordData, size), done: make(chan struct{})}
+}
+
+//take returns a prefetched word, or false when there is none ready.
+func (p *wordPool) take() (objects.WordData, bool) {
+	select {
+	case word := <-p.words:
+		return word, true
+	default:
+		return objects.WordData{}, false
+	}
+}
+
+//giveBack returns a word take handed out, unless the pool filled up since.
+func (p *wordPool) giveBack(word objects.WordData) {
+	select {
+	case p.words <- word:
+	default:
+	}
+}
+
+//stop ends fill.
+func (p *wordPool) stop() {
+	close(p.done)
+}
+
+//sleep waits for d, or returns false when the pool is stopped first.
+func (p *wordPool) sleep(d time.Duration) bool {
+	select {
+	case <-p.done:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+//fill keeps the pool topped up with words over random-votes. It blocks
+//while the pool is full and backs off while upstream is failing, until stopped.
+func (p *wordPool) fill() {
+	backoff := time.Second
+	for {
+		wd, err := dictionary.Random(context.Background())
+		if err != nil {
+			slog.Warn("Could not prefetch random words", "error", err)
+			if !p.sleep(backoff) {
+				return
+			}
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+
+		for _, element := range wd.List {
+			if element.Definition != "" && element.ThumbsUp > current().RandomVotes {
+				select {
+				case p.words <- element:
+				case <-p.done:
+					return
+				}
+			}
+		}
+		if !p.sleep(prefetchInterval) {
+			return
+		}
+	}
+}
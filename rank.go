@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+//ranking scores a definition. Higher scores rank first.
+type ranking func(wd objects.WordData, position int) float64
+
+//rankings are the strategies definitions can be ordered by.
+var rankings = map[string]ranking{
+	//thumbs ranks by raw thumbs up, as urbanobot always did.
+	"thumbs": func(wd objects.WordData, _ int) float64 {
+		return float64(wd.ThumbsUp)
+	},
+	//ratio ranks by thumbs up per thumbs down.
+	"ratio": func(wd objects.WordData, _ int) float64 {
+		return float64(wd.ThumbsUp) / float64(wd.ThumbsDown+1)
+	},
+	//wilson ranks by the lower bound of the 95% Wilson score interval, which
+	//favors definitions that are both liked and voted on a lot.
+	"wilson": func(wd objects.WordData, _ int) float64 {
+		return wilsonLowerBound(wd.ThumbsUp, wd.ThumbsDown)
+	},
+	//first keeps the order Urban Dictionary returned.
+	"first": func(_ objects.WordData, position int) float64 {
+		return 1 / float64(position+1)
+	},
+}
+
+//checkRanking makes sure name is a known strategy.
+func checkRanking(name string) error {
+	if _, ok := rankings[name]; !ok {
+		return fmt.Errorf("unknown ranking %q, use one of %v", name, rankingNames())
+	}
+	return nil
+}
+
+func rankingNames() string {
+	var names []string
+	for name := range rankings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+//rankingFor returns the strategy configured for the team u comes from.
+func rankingFor(u objects.SlackIncoming) string {
+	if t, ok := current().registry[u.SlackTeam]; ok && t.Ranking != "" {
+		return t.Ranking
+	}
+	return current().Ranking
+}
+
+//rank scores list with the named strategy and sorts it best first.
+func rank(list []objects.WordData, name string) {
+	score := rankings[name]
+	for i := range list {
+		list[i].Ranking = name
+		list[i].Score = score(list[i], i)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Score > list[j].Score
+	})
+}
+
+func wilsonLowerBound(up, down int) float64 {
+	n := float64(up + down)
+	if n == 0 {
+		return 0
+	}
+	const z = 1.96
+	p := float64(up) / n
+	return (p + z*z/(2*n) - z*math.Sqrt((p*(1-p)+z*z/(4*n))/n)) / (1 + z*z/n)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+//restartSettings only take effect on a restart; reloads leave them alone.
+var restartSettings = []string{
+	"config", "https", "port", "domain", "cert-cache",
+	"read-timeout", "write-timeout", "idle-timeout",
+	"ud-url", "cache-size", "cache-ttl", "cache-stale",
+	"random-pool", "history", "metrics-addr",
+}
+
+//reloader re-reads the configuration urbanobot was started with and swaps
+//it in.
+type reloader struct {
+	args      []string
+	schedules *scheduleRunner
+
+	mu sync.Mutex
+}
+
+//reload loads and validates the configuration again. When it is valid it
+//replaces the one in use, and the schedules are restarted with it. When
+//it isn't, nothing changes.
+func (rl *reloader) reload(reason string) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if atomic.LoadInt32(&shuttingDown) != 0 {
+		return errors.New("shutting down")
+	}
+
+	sdNotify("RELOADING=1")
+	defer sdNotify("READY=1")
+
+	c, err := loadConfig(rl.args)
+	if err != nil {
+		countEvent("config_reload_failures")
+		slog.Error("Rejected new configuration, keeping the old one", "reason", reason, "error", err)
+		return err
+	}
+
+	old := current()
+	oldFlags, newFlags := old.flagSet(), c.flagSet()
+	for _, name := range restartSettings {
+		was := oldFlags.Lookup(name).Value.String()
+		if newFlags.Lookup(name).Value.String() != was {
+			slog.Warn("Setting changed, restart to apply it", "setting", name)
+			newFlags.Set(name, was)
+			c.sources[name] = old.sources[name]
+		}
+	}
+
+	c.apply()
+	setupLogging(c.LogFormat, c.LogLevel)
+	if err := rl.schedules.run(c.jobs); err != nil {
+		slog.Error("Could not restart schedules", "error", err)
+	}
+
+	countEvent("config_reloads")
+	slog.Info("Configuration reloaded", "reason", reason, "teams", len(c.registry), "schedules", len(c.jobs))
+	return nil
+}
+
+//reloadOnSignal reloads the configuration on every SIGHUP.
+func (rl *reloader) reloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			rl.reload("SIGHUP")
+		}
+	}()
+}
+
+//serveReload reloads the configuration on POST /admin/reload. It needs
+//admin-token as a bearer token, and is off when that isn't set.
+func (rl *reloader) serveReload(w http.ResponseWriter, r *http.Request) {
+	token := current().AdminToken
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !constantTimeEqual(r.Header.Get("Authorization"), "Bearer "+token) {
+		countEvent("admin_auth_failures")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="urbanobot"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err := rl.reload("admin endpoint from " + r.RemoteAddr); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"status": "rejected", "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
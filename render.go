@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+const (
+	platformSlack      = "Slack"
+	platformMattermost = "Mattermost"
+)
+
+//maxBlockText is the most Slack accepts in a section block.
+const maxBlockText = 3000
+
+//maxButtonValue is the longest value Slack accepts on a button. It turns
+//down the whole message over a single longer one.
+const maxButtonValue = 2000
+
+//renderDefinition builds the reply showing wd under title. The plain text
+//is always set so clients that don't render blocks still get the definition.
+func renderDefinition(u objects.SlackIncoming, title string, wd objects.WordData) objects.SlackResponse {
+	response := objects.SlackResponse{}
+	response.Text = fmt.Sprintf("%s --> %s", title, wd.Definition)
+	response.ResponseType = "in_channel"
+
+	if current().Rich && u.Platform == platformSlack {
+		response.Blocks = definitionBlocks(u, title, wd)
+	}
+	return response
+}
+
+//definitionBlocks lays out the definition, the example as a quote, the
+//votes and author, a link back to Urban Dictionary and the terms it refers to.
+func definitionBlocks(u objects.SlackIncoming, title string, wd objects.WordData) []objects.Block {
+	blocks := []objects.Block{
+		{
+			Type: "section",
+			Text: mrkdwn(truncate("*"+title+"*\n"+wd.Definition, maxBlockText)),
+		},
+	}
+
+	if example := strings.TrimSpace(wd.Example); example != "" {
+		blocks = append(blocks, objects.Block{
+			Type: "section",
+			Text: mrkdwn(truncate(quote(example), maxBlockText)),
+		})
+	}
+
+	context := []interface{}{
+		mrkdwn(fmt.Sprintf(":+1: %d   :-1: %d", wd.ThumbsUp, wd.ThumbsDown)),
+	}
+	if wd.Author != "" {
+		context = append(context, mrkdwn("by "+wd.Author))
+	}
+	if wd.Ranking != "" {
+		context = append(context, mrkdwn(fmt.Sprintf("ranked by %s (%.3g)", wd.Ranking, wd.Score)))
+	}
+	blocks = append(blocks, objects.Block{Type: "context", Elements: context})
+
+	if see := seeAlso(u, wd.SeeAlso); len(see) > 0 {
+		blocks = append(blocks, see...)
+	}
+
+	if wd.Permalink != "" {
+		blocks = append(blocks, objects.Block{
+			Type: "actions",
+			Elements: []interface{}{
+				objects.Button{
+					Type:     "button",
+					Text:     plainText("View on Urban Dictionary"),
+					ActionID: "permalink",
+					URL:      wd.Permalink,
+				},
+			},
+		})
+	}
+	return blocks
+}
+
+func mrkdwn(text string) *objects.TextObject {
+	return &objects.TextObject{Type: "mrkdwn", Text: text}
+}
+
+func plainText(text string) *objects.TextObject {
+	return &objects.TextObject{Type: "plain_text", Text: text, Emoji: true}
+}
+
+//quote turns every line of text into a Slack block quote.
+func quote(text string) string {
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	for i, line := range lines {
+		lines[i] = "> " + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+//truncate shortens text to at most max runes, ending it with an ellipsis.
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+//renderList builds the reply showing several definitions of title, numbered.
+func renderList(u objects.SlackIncoming, title string, list []objects.WordData) objects.SlackResponse {
+	lines := make([]string, len(list))
+	for i, wd := range list {
+		lines[i] = fmt.Sprintf("%d. %s", i+1, wd.Definition)
+	}
+
+	response := objects.SlackResponse{}
+	response.Text = fmt.Sprintf("%s -->\n%s", title, strings.Join(lines, "\n"))
+	response.ResponseType = "in_channel"
+
+	if current().Rich && u.Platform == platformSlack {
+		response.Blocks = []objects.Block{{Type: "section", Text: mrkdwn("*" + title + "*")}}
+		for i, wd := range list {
+			response.Blocks = append(response.Blocks,
+				objects.Block{Type: "divider"},
+				objects.Block{Type: "section", Text: mrkdwn(truncate(fmt.Sprintf("*%d.* %s", i+1, wd.Definition), maxBlockText))},
+				objects.Block{Type: "context", Elements: []interface{}{mrkdwn(fmt.Sprintf(":+1: %d   :-1: %d", wd.ThumbsUp, wd.ThumbsDown))}},
+			)
+		}
+	}
+	return response
+}
+
+//renderExample builds the reply showing only the example of wd.
+func renderExample(u objects.SlackIncoming, title string, wd objects.WordData) objects.SlackResponse {
+	response := objects.SlackResponse{}
+	response.Text = fmt.Sprintf("%s --> %s", title, wd.Example)
+	response.ResponseType = "in_channel"
+
+	if current().Rich && u.Platform == platformSlack {
+		response.Blocks = []objects.Block{
+			{Type: "section", Text: mrkdwn("*" + title + "*")},
+			{Type: "section", Text: mrkdwn(truncate(quote(strings.TrimSpace(wd.Example)), maxBlockText))},
+		}
+	}
+	return response
+}
+
+//renderNotFound builds the reply for a word without definitions. Suggestions
+//are listed in the text, and offered as buttons when buttons work.
+func renderNotFound(u objects.SlackIncoming, word string, suggestions []string) objects.SlackResponse {
+	response := objects.SlackResponse{}
+	response.Text = fmt.Sprintf("%s - Word not found", word)
+	response.ResponseType = "ephemeral"
+	if len(suggestions) == 0 {
+		return response
+	}
+	shown := make([]string, len(suggestions))
+	for i, s := range suggestions {
+		shown[i] = sanitize(u.Platform, s)
+	}
+	response.Text += ". Did you mean " + strings.Join(shown, ", ") + "?"
+
+	if !canButton(u) {
+		return response
+	}
+	switch u.Platform {
+	case platformSlack:
+		buttons := make([]interface{}, len(suggestions))
+		for i, s := range suggestions {
+			if len(s) > maxButtonValue {
+				return response
+			}
+			buttons[i] = objects.Button{
+				Type:     "button",
+				Text:     plainText(truncate(s, 75)),
+				ActionID: fmt.Sprintf("%s-%d", actionDefine, i),
+				Value:    s,
+			}
+		}
+		response.Blocks = []objects.Block{
+			{Type: "section", Text: mrkdwn(fmt.Sprintf("*%s* - Word not found. Did you mean:", word))},
+			{Type: "actions", BlockID: "suggestions", Elements: buttons},
+		}
+	case platformMattermost:
+		buttons := make([]objects.AttachmentAction, len(suggestions))
+		for i, s := range suggestions {
+			state := pageState{Kind: cmdDefine, Term: s, Team: u.SlackTeam, ResponseURL: u.Response_url}
+			value := encodeState(state)
+			if len(value) > maxButtonValue {
+				return response
+			}
+			buttons[i] = mattermostButton(fmt.Sprintf("%s%d", actionDefine, i), truncate(s, 75), actionDefine, value)
+		}
+		response.Attachments = []objects.Attachment{{Text: "Did you mean:", Actions: buttons}}
+	}
+	return response
+}
+
+//seeAlso builds the footer listing cross-referenced terms: buttons that
+//define them when buttons work, links to UD otherwise.
+func seeAlso(u objects.SlackIncoming, terms []string) []objects.Block {
+	if len(terms) == 0 {
+		return nil
+	}
+	if len(terms) > maxSeeAlso {
+		terms = terms[:maxSeeAlso]
+	}
+
+	fits := true
+	for _, term := range terms {
+		fits = fits && len(slackUnescapes.Replace(term)) <= maxButtonValue
+	}
+	if !canButton(u) || !fits {
+		links := make([]string, len(terms))
+		for i, term := range terms {
+			links[i] = linkReferences(u.Platform, "["+term+"]")
+		}
+		return []objects.Block{{Type: "context", Elements: []interface{}{mrkdwn("See also: " + strings.Join(links, ", "))}}}
+	}
+
+	buttons := make([]interface{}, len(terms))
+	for i, term := range terms {
+		buttons[i] = objects.Button{
+			Type:     "button",
+			Text:     plainText(truncate(slackUnescapes.Replace(term), 75)),
+			ActionID: fmt.Sprintf("%s-%d", actionSeeAlso, i),
+			Value:    slackUnescapes.Replace(term),
+		}
+	}
+	return []objects.Block{
+		{Type: "context", Elements: []interface{}{mrkdwn("See also")}},
+		{Type: "actions", BlockID: "see_also", Elements: buttons},
+	}
+}
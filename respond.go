@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+//postAttempts is how many times a deferred reply is posted before giving up.
+const postAttempts = 4
+
+var responseClient = &http.Client{Timeout: 10 * time.Second}
+
+//reply answers a slash command with the result of answer. When the command
+//came with a response_url readIncoming trusts, the request is acknowledged
+//straight away and answer runs in the background, so a slow lookup doesn't
+//run into Slack's three second timeout.
+func reply(w http.ResponseWriter, r *http.Request, u objects.SlackIncoming, answer func(context.Context) (objects.SlackResponse, error)) {
+	c := current()
+	if !c.Defer || u.Response_url == "" {
+		response, err := answer(r.Context())
+		if err != nil {
+			setOutcome(r.Context(), "error")
+			logFor(r.Context()).Error("Could not answer", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		sendResponse(w, response)
+		return
+	}
+
+	if c.AckText == "" {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		response := objects.SlackResponse{}
+		response.Text = c.AckText
+		response.ResponseType = "ephemeral"
+		sendResponse(w, response)
+	}
+
+	//The answer outlives the request, but keeps its ID and fields.
+	ctx := context.WithoutCancel(r.Context())
+	setOutcome(ctx, "deferred")
+	goBackground(func() {
+		response, err := answer(ctx)
+		if err != nil {
+			setOutcome(ctx, "error")
+			logFor(ctx).Error("Could not answer", "error", err)
+			response = objects.SlackResponse{}
+			response.Text = "Urban Dictionary is not answering right now, try again in a bit."
+			response.ResponseType = "ephemeral"
+		}
+		if err := postResponse(ctx, u.Response_url, response); err != nil {
+			countEvent("response_url_failures")
+			setOutcome(ctx, "undelivered")
+			logFor(ctx).Error("Giving up on deferred reply", "error", err)
+		}
+		logRequest(ctx, "Deferred reply finished")
+	})
+}
+
+//sendResponse writes response as the JSON reply to a slash command.
+func sendResponse(w http.ResponseWriter, response objects.SlackResponse) {
+	response.BotVersion = version
+
+	resp, err := json.Marshal(response)
+	if err != nil {
+		slog.Error("Could not marshal response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	//Mattermost shows anything that isn't application/json as plain text.
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+//slackResponseHost is where Slack's response_urls point.
+const slackResponseHost = "hooks.slack.com"
+
+var errUnauthenticated = errors.New("request is neither signed nor from a registered team")
+
+//trustResponseURL tells whether replies to r may be posted to responseURL:
+//r was signed or carried a registered team's token, and the URL passes
+//checkResponseURL. Signed requests come from Slack whatever they claim.
+func trustResponseURL(r *http.Request, platform, responseURL string) error {
+	signed := isSigned(r)
+	if !signed && len(current().registry) == 0 {
+		return errUnauthenticated
+	}
+	if signed {
+		platform = platformSlack
+	}
+	return checkResponseURL(platform, responseURL)
+}
+
+//checkResponseURL makes sure responseURL is https, and on Slack's host for
+//Slack.
+func checkResponseURL(platform, responseURL string) error {
+	u, err := url.Parse(responseURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+		return fmt.Errorf("response_url %q is not an https URL", responseURL)
+	}
+	if platform == platformSlack && u.Hostname() != slackResponseHost {
+		return fmt.Errorf("response_url %q is not on %v", responseURL, slackResponseHost)
+	}
+	return nil
+}
+
+//postResponse delivers response to a response_url, retrying with
+//exponential backoff on network errors, throttling and server errors.
+func postResponse(ctx context.Context, url string, response objects.SlackResponse) error {
+	response.BotVersion = version
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = postJSON(url, body)
+		if err == nil || attempt == postAttempts {
+			return err
+		}
+		if _, permanent := err.(permanentError); permanent {
+			return err
+		}
+
+		logFor(ctx).Warn("Posting to response_url failed", "attempt", attempt, "attempts", postAttempts, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+//permanentError is a failure that retrying won't fix.
+type permanentError struct {
+	status string
+}
+
+func (e permanentError) Error() string {
+	return "rejected with " + e.status
+}
+
+func postJSON(url string, body []byte) error {
+	resp, err := responseClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("got %v", resp.Status)
+	default:
+		return permanentError{resp.Status}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestCheckResponseURL(t *testing.T) {
+	tests := []struct {
+		platform, url string
+		ok            bool
+	}{
+		{platformSlack, "https://hooks.slack.com/commands/T1/1/abc", true},
+		{platformSlack, "http://hooks.slack.com/commands/T1/1/abc", false},
+		{platformSlack, "https://hooks.slack.com.evil.example/x", false},
+		{platformSlack, "https://evil.example/hooks.slack.com", false},
+		{platformSlack, "https://user@hooks.slack.com/x", false},
+		{platformSlack, "https://127.0.0.1/x", false},
+		{platformSlack, "", false},
+		{platformMattermost, "https://chat.example.com/hooks/commands/abc", true},
+		{platformMattermost, "http://chat.example.com/hooks/commands/abc", false},
+		{platformMattermost, "https:///hooks/commands/abc", false},
+		{platformMattermost, "hooks/commands/abc", false},
+	}
+	for _, test := range tests {
+		if err := checkResponseURL(test.platform, test.url); (err == nil) != test.ok {
+			t.Errorf("checkResponseURL(%v, %q) = %v, want ok %v", test.platform, test.url, err, test.ok)
+		}
+	}
+}
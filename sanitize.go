@@ -0,0 +1,54 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+//zeroWidthSpace breaks up mentions without changing how they read.
+const zeroWidthSpace = "\u200b"
+
+var (
+	slackEscapes = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+	//atMention matches @all, @channel, @here, @someone and the like.
+	atMention = regexp.MustCompile(`@([\w.-])`)
+
+	//markdownLink matches the ]( joining a Markdown link's text and target.
+	markdownLink = regexp.MustCompile(`\]\s*\(`)
+
+	trailingSpace = regexp.MustCompile(`[ \t]+\n`)
+)
+
+//sanitize makes text from Urban Dictionary or the user safe to post on
+//platform: no mentions or broadcasts that would ping anyone, no link markup,
+//and tidy line endings.
+func sanitize(platform, text string) string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+	text = trailingSpace.ReplaceAllString(text, "\n")
+	text = strings.TrimSpace(text)
+
+	switch platform {
+	case platformSlack:
+		//Escaping <, > and & is all Slack needs to show <!channel> or
+		//<@U123> as text instead of acting on it.
+		text = slackEscapes.Replace(text)
+	case platformMattermost:
+		text = markdownLink.ReplaceAllString(text, "]"+zeroWidthSpace+"(")
+	}
+	return atMention.ReplaceAllString(text, "@"+zeroWidthSpace+"$1")
+}
+
+//sanitizeDefinitions cleans up the upstream text in list for platform.
+func sanitizeDefinitions(platform string, list []objects.WordData) []objects.WordData {
+	for i, wd := range list {
+		list[i].Word = sanitize(platform, wd.Word)
+		list[i].Definition = sanitize(platform, wd.Definition)
+		list[i].Example = sanitize(platform, wd.Example)
+		list[i].Author = sanitize(platform, wd.Author)
+	}
+	return list
+}
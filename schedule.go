@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+//defaultAvoidDays is how long a word of the day isn't repeated by default.
+const defaultAvoidDays = 30
+
+//scheduledJob is a schedule ready to run.
+type scheduledJob struct {
+	objects.Schedule
+	spec cronSpec
+	loc  *time.Location
+}
+
+//scheduler posts a word of the day for each of its jobs.
+type scheduler struct {
+	jobs    []scheduledJob
+	history *wordHistory
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+//loadSchedules reads a JSON list of objects.Schedule from path.
+func loadSchedules(path string) ([]scheduledJob, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []objects.Schedule
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	return prepareSchedules(list)
+}
+
+//prepareSchedules checks each schedule and parses its cron expression and time zone.
+func prepareSchedules(list []objects.Schedule) ([]scheduledJob, error) {
+	var jobs []scheduledJob
+	names := make(map[string]bool)
+	for _, s := range list {
+		if s.Name == "" || names[s.Name] {
+			return nil, fmt.Errorf("schedule %q: every schedule needs a unique name", s.Name)
+		}
+		names[s.Name] = true
+
+		spec, err := parseCron(s.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %v: %v", s.Name, err)
+		}
+		loc, err := time.LoadLocation(s.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %v: %v", s.Name, err)
+		}
+		if len(s.Targets) == 0 {
+			return nil, fmt.Errorf("schedule %v: no targets", s.Name)
+		}
+		for i, t := range s.Targets {
+			platform, ok := platformNamed(t.Platform)
+			if !ok || t.URL == "" {
+				return nil, fmt.Errorf("schedule %v: target %d needs a url and a platform, slack or mattermost", s.Name, i+1)
+			}
+			s.Targets[i].Platform = platform
+		}
+		if s.AvoidDays == 0 {
+			s.AvoidDays = defaultAvoidDays
+		}
+
+		jobs = append(jobs, scheduledJob{Schedule: s, spec: spec, loc: loc})
+	}
+	return jobs, nil
+}
+
+//platformNamed maps a platform as written in config to its constant.
+func platformNamed(name string) (string, bool) {
+	switch strings.ToLower(name) {
+	case "slack":
+		return platformSlack, true
+	case "mattermost":
+		return platformMattermost, true
+	}
+	return "", false
+}
+
+func newScheduler(jobs []scheduledJob, history *wordHistory) *scheduler {
+	return &scheduler{jobs: jobs, history: history, stop: make(chan struct{})}
+}
+
+//start runs every job in the background until stopped.
+func (s *scheduler) start() {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.run(job)
+	}
+}
+
+//shutdown stops the jobs and waits for any post in progress.
+func (s *scheduler) shutdown() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+//scheduleRunner keeps one scheduler running for the jobs currently
+//configured, replacing it when they change.
+type scheduleRunner struct {
+	historyPath string
+
+	mu      sync.Mutex
+	history *wordHistory
+	current *scheduler
+}
+
+//run stops the jobs running now, waiting for any post in progress, and
+//starts jobs instead. The word history is opened with the first jobs.
+func (r *scheduleRunner) run(jobs []scheduledJob) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.history == nil && len(jobs) > 0 {
+		history, err := openHistory(r.historyPath)
+		if err != nil {
+			return err
+		}
+		r.history = history
+		addReadyCheck("history", history.status)
+	}
+
+	if r.current != nil {
+		r.current.shutdown()
+		r.current = nil
+	}
+	if len(jobs) > 0 {
+		r.current = newScheduler(jobs, r.history)
+		r.current.start()
+	}
+	return nil
+}
+
+//shutdown stops the running jobs.
+func (r *scheduleRunner) shutdown() {
+	r.run(nil)
+}
+
+func (s *scheduler) run(job scheduledJob) {
+	defer s.wg.Done()
+
+	for {
+		next := job.spec.next(time.Now().In(job.loc))
+		if next.IsZero() {
+			slog.Warn("Schedule never runs, stopping it", "schedule", job.Name)
+			return
+		}
+		slog.Info("Next word of the day scheduled", "schedule", job.Name, "at", next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		//Each run gets a request ID of its own, so its upstream calls and
+		//deliveries can be told apart in the logs.
+		ctx := withRequestInfo(context.Background(), "")
+		if err := s.post(ctx, job); err != nil {
+			countEvent("schedule_failures")
+			logFor(ctx).Error("Word of the day failed", "schedule", job.Name, "error", err)
+		}
+	}
+}
+
+//post picks a word and sends it to every target of job.
+func (s *scheduler) post(ctx context.Context, job scheduledJob) error {
+	wd, err := s.pickWord(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	delivered := 0
+	for _, target := range job.Targets {
+		if err := postResponse(ctx, target.URL, renderWordOfTheDay(target.Platform, wd)); err != nil {
+			logFor(ctx).Warn("Could not post word of the day", "schedule", job.Name, "platform", target.Platform, "error", err)
+			continue
+		}
+		delivered++
+	}
+	if delivered == 0 {
+		return fmt.Errorf("no target took %v", wd.Word)
+	}
+
+	countEvent("schedule_posts")
+	logFor(ctx).Info("Posted word of the day", "schedule", job.Name, "word", wd.Word, "delivered", delivered, "targets", len(job.Targets))
+
+	keep := time.Duration(job.AvoidDays) * 24 * time.Hour
+	return s.history.record(job.Name, postedWord{Word: wd.Word, Defid: wd.Defid, Posted: time.Now()}, keep)
+}
+
+//pickWord finds a random word that passes the filter and that job hasn't
+//posted in its last AvoidDays.
+func (s *scheduler) pickWord(ctx context.Context, job scheduledJob) (objects.WordData, error) {
+	since := time.Now().AddDate(0, 0, -job.AvoidDays)
+	wd, err := getNewWord(ctx, func(wd objects.WordData) (objects.WordData, bool) {
+		if s.history.seen(job.Name, wd.Word, since) {
+			return wd, false
+		}
+		list := filterDefinitions(ctx, objects.SlackIncoming{}, []objects.WordData{wd}, false)
+		if len(list) == 0 || list[0].Flagged {
+			return wd, false
+		}
+		return list[0], true
+	})
+	if err == errNoRandomWord {
+		return wd, fmt.Errorf("no fresh word in %v random pages", current().RandomAttempts)
+	}
+	return wd, err
+}
+
+//renderWordOfTheDay builds the webhook message for wd on platform.
+func renderWordOfTheDay(platform string, wd objects.WordData) objects.SlackResponse {
+	u := objects.SlackIncoming{Platform: platform}
+	wd = linkDefinitions(platform, sanitizeDefinitions(platform, []objects.WordData{wd}))[0]
+
+	response := renderDefinition(u, wd.Word, wd)
+	response.Text = "Word of the Day: " + response.Text
+	if len(response.Blocks) > 0 {
+		response.Blocks = append([]objects.Block{{Type: "header", Text: plainText("Word of the Day")}}, response.Blocks...)
+	}
+	return response
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+//background tracks work that outlives the request that started it, like
+//deferred replies, so shutdown can wait for it.
+var background sync.WaitGroup
+
+//goBackground runs f in its own goroutine, tracked by background.
+func goBackground(f func()) {
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		f()
+	}()
+}
+
+//newServer returns a server for handler on addr with the configured timeouts.
+func newServer(addr string, handler http.Handler) *http.Server {
+	c := current()
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       c.ReadTimeout,
+		ReadHeaderTimeout: c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+	}
+}
+
+//shutdownOnSignal waits for SIGTERM or SIGINT, reports not ready for
+//shutdown-delay, then stops servers from taking new connections and gives
+//in-flight requests, deferred replies and stop's jobs until shutdown-timeout
+//to finish. The returned channel is closed when it's done.
+func shutdownOnSignal(servers []*http.Server, stop ...func()) <-chan struct{} {
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		defer close(done)
+		sig := <-signals
+		signal.Stop(signals)
+
+		atomic.StoreInt32(&shuttingDown, 1)
+		sdNotify("STOPPING=1")
+		c := current()
+		slog.Info("Shutting down", "signal", sig.String(), "delay", c.ShutdownDelay.String(), "deadline", c.ShutdownTimeout.String())
+
+		//Keep serving while load balancers see /readyz fail and move away.
+		time.Sleep(c.ShutdownDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout)
+		defer cancel()
+
+		var wg sync.WaitGroup
+		for _, server := range servers {
+			wg.Add(1)
+			go func(server *http.Server) {
+				defer wg.Done()
+				if err := server.Shutdown(ctx); err != nil {
+					slog.Warn("Server did not drain in time", "addr", server.Addr, "error", err)
+				}
+			}(server)
+		}
+		for _, f := range stop {
+			wg.Add(1)
+			go func(f func()) {
+				defer wg.Done()
+				if err := waitFor(ctx, f); err != nil {
+					slog.Warn("Background job did not stop in time", "error", err)
+				}
+			}(f)
+		}
+		wg.Wait()
+
+		//Requests are done, but their deferred replies may not be.
+		if err := waitFor(ctx, background.Wait); err != nil {
+			slog.Warn("Gave up on background work", "error", err)
+			return
+		}
+		slog.Info("Shut down cleanly")
+	}()
+	return done
+}
+
+//waitFor runs wait until it returns or ctx is done.
+func waitFor(ctx context.Context, wait func()) error {
+	finished := make(chan struct{})
+	go func() {
+		wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import "expvar"
+
+//stats holds the counters urbanobot keeps about itself.
+var stats = expvar.NewMap("urbanobot")
+
+//countEvent increments the named counter by one.
+func countEvent(name string) {
+	stats.Add(name, 1)
+}
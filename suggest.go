@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+//maxSuggestions is how many alternatives are offered for an unknown word.
+const maxSuggestions = 5
+
+//suggestTerms returns words close to term, for when it has no definition.
+func suggestTerms(ctx context.Context, term string) []string {
+	list, err := dictionary.Suggest(ctx, term)
+	if err != nil {
+		logFor(ctx).Warn("Could not get suggestions", "error", err)
+	}
+
+	key := cacheKey(term)
+	seen := map[string]bool{key: true}
+	var suggestions []string
+	for _, s := range list {
+		if k := cacheKey(s); k != "" && !seen[k] && len(suggestions) < maxSuggestions {
+			seen[k] = true
+			suggestions = append(suggestions, s)
+		}
+	}
+	return suggestions
+}
+
+//similarTerms picks the terms closest to term by edit distance, or by
+//trigram overlap for longer phrases where typos add up.
+func similarTerms(term string, known []string) []string {
+	type candidate struct {
+		term     string
+		distance int
+		overlap  float64
+	}
+
+	key := cacheKey(term)
+	var candidates []candidate
+	for _, k := range known {
+		other := cacheKey(k)
+		if other == key {
+			continue
+		}
+		c := candidate{term: k, distance: levenshtein(key, other), overlap: trigramSimilarity(key, other)}
+		if c.distance <= maxDistance(key) || c.overlap >= 0.4 {
+			candidates = append(candidates, c)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].overlap > candidates[j].overlap
+	})
+
+	var terms []string
+	for _, c := range candidates {
+		terms = append(terms, c.term)
+	}
+	return terms
+}
+
+//maxDistance is how many edits still count as a typo of term.
+func maxDistance(term string) int {
+	if n := utf8.RuneCountInString(term) / 3; n > 1 {
+		return n
+	}
+	return 1
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j] + 1
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+//trigramSimilarity is the Jaccard index of the trigrams of a and b.
+func trigramSimilarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+func trigrams(s string) map[string]bool {
+	r := []rune("  " + strings.Replace(s, " ", "  ", -1) + " ")
+	set := make(map[string]bool)
+	for i := 0; i+3 <= len(r); i++ {
+		set[string(r[i:i+3])] = true
+	}
+	return set
+}
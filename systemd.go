@@ -0,0 +1,4 @@
+package main
+
+//metricsSocket is the FileDescriptorName of a socket meant for metrics.
+const metricsSocket = "metrics"
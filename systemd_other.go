@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import "net"
+
+//systemdListeners returns nothing: there's no systemd to pass sockets here.
+func systemdListeners() (map[string][]net.Listener, error) {
+	return nil, nil
+}
+
+//sdNotify does nothing without systemd.
+func sdNotify(state string) {}
+
+//startWatchdog does nothing without systemd.
+func startWatchdog() {}
@@ -0,0 +1,108 @@
+//go:build unix
+
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//listenFdsStart is the first file descriptor systemd passes sockets on.
+const listenFdsStart = 3
+
+//systemdListeners returns the sockets systemd passed through socket
+//activation, keyed by their FileDescriptorName. It returns nothing when the
+//process wasn't socket activated. The variables are cleared so processes we
+//start don't take the sockets for theirs.
+func systemdListeners() (map[string][]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make(map[string][]net.Listener)
+	for i := 0; i < count; i++ {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		listeners[name] = append(listeners[name], l)
+	}
+	return listeners, nil
+}
+
+//sdNotify tells systemd about a change of state, like "READY=1". It does
+//nothing when systemd isn't listening.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	//Sockets in the abstract namespace start with @.
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("Could not notify systemd", "state", state, "error", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Warn("Could not notify systemd", "state", state, "error", err)
+	}
+}
+
+//watchdogInterval returns how often systemd expects a keepalive, or zero
+//when the watchdog is off or meant for another process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+//startWatchdog pings systemd's watchdog at half the interval it asked for,
+//so a hung process gets restarted.
+func startWatchdog() {
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	slog.Info("Feeding the systemd watchdog", "interval", interval.String())
+	go func() {
+		for range time.Tick(interval / 2) {
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+var (
+	errUnknownTeam = errors.New("team not authorized")
+	errBadToken    = errors.New("verification token mismatch")
+)
+
+//loadTeams reads the team registry, a JSON list of objects.Team, from path.
+func loadTeams(path string) (map[string]objects.Team, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []objects.Team
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+
+	registry, err := newRegistry(list)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return registry, nil
+}
+
+//newRegistry checks the teams in list and maps them by team_id.
+func newRegistry(list []objects.Team) (map[string]objects.Team, error) {
+	registry := make(map[string]objects.Team, len(list))
+	for _, t := range list {
+		if t.ID == "" {
+			return nil, errors.New("team without team_id")
+		}
+		if t.Ranking != "" {
+			if err := checkRanking(t.Ranking); err != nil {
+				return nil, fmt.Errorf("team %v: %v", t.ID, err)
+			}
+		}
+		if err := checkTeamFilters(t); err != nil {
+			return nil, fmt.Errorf("team %v: %v", t.ID, err)
+		}
+		if _, ok := registry[t.ID]; ok {
+			return nil, fmt.Errorf("team %v listed twice", t.ID)
+		}
+		registry[t.ID] = t
+	}
+	return registry, nil
+}
+
+//authorizeTeam checks that u comes from an approved team carrying its token.
+//Unsigned requests must always present a token; signed Slack requests only
+//when one is registered for the team.
+func authorizeTeam(u objects.SlackIncoming, signed bool) error {
+	teams := current().registry
+	if len(teams) == 0 {
+		return nil
+	}
+
+	t, ok := teams[u.SlackTeam]
+	if !ok {
+		return errUnknownTeam
+	}
+	if t.Token == "" && signed {
+		return nil
+	}
+	if t.Token == "" || !constantTimeEqual(t.Token, u.Token) {
+		return errBadToken
+	}
+	return nil
+}
+
+//checkTeamFilters makes sure every filter policy set for t is known.
+func checkTeamFilters(t objects.Team) error {
+	if t.Filter != "" {
+		if err := checkFilter(t.Filter); err != nil {
+			return err
+		}
+	}
+	for name, c := range t.Channels {
+		if c.Filter != "" {
+			if err := checkFilter(c.Filter); err != nil {
+				return fmt.Errorf("channel %v: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+func TestAuthorizeTeam(t *testing.T) {
+	c := defaultConfig()
+	useConfig(t, c)
+	if err := authorizeTeam(objects.SlackIncoming{SlackTeam: "T9"}, false); err != nil {
+		t.Errorf("without teams: authorizeTeam = %v, want everyone let in", err)
+	}
+
+	registry, err := newRegistry([]objects.Team{
+		{ID: "T1", Domain: "tokens", Token: "tok", Platform: platformSlack},
+		{ID: "T2", Domain: "signed", Platform: platformSlack},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.registry = registry
+
+	tests := []struct {
+		team, token string
+		signed      bool
+		want        error
+	}{
+		{"T1", "tok", false, nil},
+		{"T1", "tok", true, nil},
+		{"T1", "bad", false, errBadToken},
+		{"T1", "bad", true, errBadToken},
+		{"T1", "", false, errBadToken},
+		{"T2", "", true, nil},
+		{"T2", "", false, errBadToken},
+		{"T2", "anything", false, errBadToken},
+		{"T3", "tok", false, errUnknownTeam},
+		{"T3", "", true, errUnknownTeam},
+	}
+	for _, test := range tests {
+		u := objects.SlackIncoming{SlackTeam: test.team, Token: test.token}
+		if got := authorizeTeam(u, test.signed); got != test.want {
+			t.Errorf("authorizeTeam(team %v, token %q, signed %v) = %v, want %v", test.team, test.token, test.signed, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//slackSign signs body the way Slack does.
+func slackSign(secret, timestamp, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestCheckSignature(t *testing.T) {
+	c := defaultConfig()
+	c.SigningSecret = "s3cret"
+	now := time.Unix(1700000000, 0)
+	at := func(offset time.Duration) string {
+		return strconv.FormatInt(now.Add(offset).Unix(), 10)
+	}
+	const body = "token=x&team_id=T1&text=hello"
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		received  string
+		signature string //replaces the computed signature when set, "-" leaves it out
+		want      error
+	}{
+		{name: "valid", secret: "s3cret", timestamp: at(0)},
+		{name: "tampered body", secret: "s3cret", timestamp: at(0), received: body + "&text=bye", want: errBadSignature},
+		{name: "wrong secret", secret: "other", timestamp: at(0), want: errBadSignature},
+		{name: "just inside the window", secret: "s3cret", timestamp: at(-c.ReplayWindow)},
+		{name: "just outside the window", secret: "s3cret", timestamp: at(-c.ReplayWindow - time.Second), want: errStaleTimestamp},
+		{name: "too far in the future", secret: "s3cret", timestamp: at(c.ReplayWindow + time.Second), want: errStaleTimestamp},
+		{name: "non-numeric timestamp", secret: "s3cret", timestamp: "yesterday", want: errStaleTimestamp},
+		{name: "malformed signature", secret: "s3cret", timestamp: at(0), signature: "v0=nope", want: errBadSignature},
+		{name: "missing timestamp", secret: "s3cret", want: errMissingSignature},
+		{name: "missing signature", secret: "s3cret", timestamp: at(0), signature: "-", want: errMissingSignature},
+	}
+	for _, test := range tests {
+		signature := slackSign(test.secret, test.timestamp, body)
+		if test.signature != "" {
+			signature = test.signature
+		}
+		received := body
+		if test.received != "" {
+			received = test.received
+		}
+
+		h := make(http.Header)
+		if signature != "-" {
+			h.Set("X-Slack-Signature", signature)
+		}
+		if test.timestamp != "" {
+			h.Set("X-Slack-Request-Timestamp", test.timestamp)
+		}
+		if got := checkSignature(c, h, []byte(received), now); got != test.want {
+			t.Errorf("%v: checkSignature = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gitlab.com/iarenzana/urbanobot/objects"
+)
+
+var errNoExample = errors.New("no example")
+
+//previewFor reports whether replies to u start as a private preview. A
+//channel setting beats the team's, which beats the global one.
+func previewFor(u objects.SlackIncoming) bool {
+	if t, ok := current().registry[u.SlackTeam]; ok {
+		if c, ok := channelSettings(t, u); ok && c.Preview != nil {
+			return *c.Preview
+		}
+		if t.Preview != nil {
+			return *t.Preview
+		}
+	}
+	return current().Preview
+}
+
+//channelSettings finds the settings of the channel u was sent from.
+func channelSettings(t objects.Team, u objects.SlackIncoming) (objects.Channel, bool) {
+	if c, ok := t.Channels[u.Channel_id]; ok && u.Channel_id != "" {
+		return c, true
+	}
+	c, ok := t.Channels[u.SlackChannel]
+	return c, ok && u.SlackChannel != ""
+}
+
+//view renders the message state describes, without buttons, and returns how
+//many definitions can be paged through. The index is clamped into range, and
+//Hidden is set when the content filter hid something behind a spoiler.
+func view(ctx context.Context, u objects.SlackIncoming, state *pageState) (objects.SlackResponse, int, error) {
+	var list []objects.WordData
+	var err error
+	if state.Kind == cmdID {
+		var wd objects.WordData
+		wd, err = dictionary.Lookup(ctx, state.Defid)
+		list = []objects.WordData{wd}
+	} else {
+		list, err = getDefinitions(ctx, state.Term, rankingFor(u))
+	}
+	if err != nil {
+		return objects.SlackResponse{}, 0, err
+	}
+
+	list = filterDefinitions(ctx, u, list, state.Reveal)
+	if len(list) == 0 {
+		return objects.SlackResponse{}, 0, errFiltered
+	}
+	list = linkDefinitions(u.Platform, sanitizeDefinitions(u.Platform, list))
+	title := sanitize(u.Platform, state.Term)
+
+	switch state.Kind {
+	case cmdID:
+		state.Hidden = list[0].Flagged
+		return renderDefinition(u, list[0].Word, list[0]), 0, nil
+	case cmdTop:
+		if len(list) > state.Count {
+			list = list[:state.Count]
+		}
+		for _, wd := range list {
+			state.Hidden = state.Hidden || wd.Flagged
+		}
+		return renderList(u, title, list), 0, nil
+	case cmdExample:
+		for i := state.Index; i < len(list); i++ {
+			if strings.TrimSpace(list[i].Example) != "" || list[i].Flagged {
+				state.Index, state.Hidden = i, list[i].Flagged
+				if list[i].Flagged {
+					return renderDefinition(u, title, list[i]), 0, nil
+				}
+				return renderExample(u, title, list[i]), 0, nil
+			}
+		}
+		return objects.SlackResponse{}, 0, errNoExample
+	}
+
+	if state.Index >= len(list) {
+		state.Index = len(list) - 1
+	}
+	if state.Index < 0 {
+		state.Index = 0
+	}
+	state.Hidden = list[state.Index].Flagged
+	return renderDefinition(u, title, list[state.Index]), len(list), nil
+}
+
+//show renders the message state describes, ready to send as a new reply.
+func show(ctx context.Context, u objects.SlackIncoming, state pageState) (objects.SlackResponse, error) {
+	return render(ctx, u, state, present)
+}
+
+//reshow renders the message state describes again for a button clicked on
+//it, which stays a preview or a channel message as it was.
+func reshow(ctx context.Context, u objects.SlackIncoming, state pageState) (objects.SlackResponse, error) {
+	return render(ctx, u, state, addControls)
+}
+
+//render views state and hands the result to finish for its buttons. Words
+//that can't be shown get an ephemeral explanation instead.
+func render(ctx context.Context, u objects.SlackIncoming, state pageState, finish func(objects.SlackIncoming, objects.SlackResponse, pageState, int) objects.SlackResponse) (objects.SlackResponse, error) {
+	response, total, err := view(ctx, u, &state)
+	switch err {
+	case nil:
+		setOutcome(ctx, "ok")
+		return finish(u, response, state, total), nil
+	case errNotFound:
+		if state.Kind == cmdID {
+			return notFound(ctx, u, fmt.Sprintf("id:%d", state.Defid)), nil
+		}
+		return notFound(ctx, u, state.Term), nil
+	case errFiltered:
+		setOutcome(ctx, "filtered")
+		response := objects.SlackResponse{}
+		response.Text = fmt.Sprintf("%s - Every definition was filtered in this channel", sanitize(u.Platform, state.Term))
+		response.ResponseType = "ephemeral"
+		return response, nil
+	case errNoExample:
+		setOutcome(ctx, "no_example")
+		response := objects.SlackResponse{}
+		response.Text = fmt.Sprintf("%s - No examples found", sanitize(u.Platform, state.Term))
+		response.ResponseType = "ephemeral"
+		return response, nil
+	}
+	return response, err
+}
+
+//attribute turns a preview into the message posted to the channel, crediting
+//the user who confirmed it.
+func attribute(u objects.SlackIncoming, response objects.SlackResponse) objects.SlackResponse {
+	response.ResponseType = "in_channel"
+	response.Text += "\n(posted by @" + u.SlackUser + ")"
+	if len(response.Blocks) > 0 && u.User_id != "" {
+		response.Blocks = append(response.Blocks, objects.Block{
+			Type:     "context",
+			Elements: []interface{}{mrkdwn("Posted by <@" + u.User_id + ">")},
+		})
+	}
+	return response
+}